@@ -3,63 +3,150 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"cloud.google.com/go/bigtable"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 
+	"github.com/ryutah/gcp-sample/go/internal/history"
+	"github.com/ryutah/gcp-sample/go/internal/payload"
 	"github.com/ryutah/gcp-sample/go/internal/stats"
 	validator "gopkg.in/go-playground/validator.v9"
 )
 
 type config struct {
-	Table    string `validate:"required"`
-	Project  string `validate:"required"`
-	Instance string `validate:"required"`
+	Table     string `validate:"required"`
+	Project   string `validate:"required"`
+	Instance  string `validate:"required"`
+	Family    string `validate:"required"`
+	Qualifier string `validate:"required"`
+
+	Qualifiers int `validate:"min=1"`
+
+	AdminRetries   int `validate:"min=0"`
+	VersionsPerKey int `validate:"min=0"`
+	GCMaxVersions  int `validate:"min=0"`
+
+	ReadMode   string `validate:"oneof=point scan"`
+	ScanPrefix string
+	ScanLimit  int `validate:"min=1"`
+
+	ReadStaleness    time.Duration `validate:"min=0"`
+	StaleReadPercent int           `validate:"min=0,max=100"`
+
+	VerifyCleanup bool
+	ReuseTable    bool
+
+	GRPCPoolSize     int           `validate:"min=0"`
+	KeepaliveTime    time.Duration `validate:"min=0"`
+	KeepaliveTimeout time.Duration `validate:"min=0"`
 }
 
 func (c *config) registerFlags() {
 	flag.StringVar(&c.Table, "table", "scratch", "name of table to use; should not already exist")
 	flag.StringVar(&c.Project, "project", "", "name of project to use")
 	flag.StringVar(&c.Instance, "instance", "", "name of instance to use")
+	flag.StringVar(&c.Family, "family", "value", "column family name to read and write")
+	flag.StringVar(&c.Qualifier, "qualifier", "col", "column qualifier to read and write")
+	flag.IntVar(&c.Qualifiers, "qualifiers", 1, "number of qualifiers written per op with known values, and read back and verified in a ReadRow; qualifiers are named -qualifier with the index when more than 1")
+	flag.IntVar(&c.AdminRetries, "admin_retries", 3, "number of retries of table setup on Unavailable or DeadlineExceeded")
+	flag.IntVar(&c.VersionsPerKey, "versions_per_key", 0, "number of distinct cell timestamps written per key; 0 to use the current time on every write")
+	flag.IntVar(&c.GCMaxVersions, "gc_max_versions", 0, "max versions GC policy of the column family; 0 to keep all versions")
+	flag.StringVar(&c.ReadMode, "read_mode", "point", "read operation to run; point to read a row, scan to read rows")
+	flag.StringVar(&c.ScanPrefix, "scan_prefix", "", "row key prefix to scan on scan mode; empty to scan from the row of the operation")
+	flag.IntVar(&c.ScanLimit, "scan_limit", 10, "max number of rows to read on scan mode")
+	flag.DurationVar(&c.ReadStaleness, "read_staleness", 0, "read the latest versions older than this duration by a timestamp range filter, to measure reads of old versions; 0 to read the latest versions")
+	flag.IntVar(&c.StaleReadPercent, "stale_read_percent", 100, "percentage of reads which read as of -read_staleness; the others read the latest versions for comparison")
+	flag.BoolVar(&c.VerifyCleanup, "verify_cleanup", false, "verify the table is deleted after the test, and exit non-zero if not")
+	flag.BoolVar(&c.ReuseTable, "reuse_table", false, "run on the existing -table, which must have -family, instead of creating it, and keep it after the test")
+	flag.IntVar(&c.GRPCPoolSize, "grpc_pool_size", 0, "number of gRPC connections of the data client; 0 to use the library default")
+	flag.DurationVar(&c.KeepaliveTime, "keepalive_time", 0, "interval of gRPC keepalive pings; 0 to disable keepalive")
+	flag.DurationVar(&c.KeepaliveTimeout, "keepalive_timeout", 20*time.Second, "timeout of gRPC keepalive pings")
 }
 
 func (c config) validate() error {
-	return validator.New().Struct(c)
+	if err := validator.New().Struct(c); err != nil {
+		return err
+	}
+	if c.Qualifiers > 1 && c.ReadMode != "point" {
+		return errors.New("-qualifiers requires -read_mode=point")
+	}
+	return nil
 }
 
-func initialize() (*config, *stats.Stats, error) {
+func initialize() (*config, *stats.Stats, *payload.Config, error) {
 	var (
 		conf  = new(config)
 		sConf = stats.NewConfig()
+		pConf = payload.NewConfig()
 	)
+	sConf.Backend = "bigtable"
 	conf.registerFlags()
 	sConf.RegisterFlags()
-	flag.Parse()
+	pConf.RegisterFlags()
+	if err := sConf.ParseFlags(); err != nil {
+		return nil, nil, nil, err
+	}
 
 	if err := conf.validate(); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	if err := sConf.Validate(); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	return conf, stats.NewStats(sConf), nil
+	if err := pConf.Validate(); err != nil {
+		return nil, nil, nil, err
+	}
+	return conf, stats.NewStats(sConf), pConf, nil
 }
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the test. errors are returned instead of exiting, so deferred
+// cleanup runs even if the test fails.
+func run() (err error) {
 	ctx := context.Background()
-	conf, sts, err := initialize()
+	conf, sts, pConf, err := initialize()
 	if err != nil {
-		log.Fatalf(err.Error())
+		return err
+	}
+
+	if sts.Config.Estimate {
+		p, err := sts.Config.Project(pConf.RowSize)
+		if err != nil {
+			return err
+		}
+		fmt.Print(p)
+		return nil
 	}
+	var (
+		codec = payload.NewCodec(pConf)
+		gen   = payload.NewGenerator(pConf)
+	)
 
 	var (
 		adminClient, adminClientErr = bigtable.NewAdminClient(ctx, conf.Project, conf.Instance)
-		client, clientErr           = bigtable.NewClient(ctx, conf.Project, conf.Instance)
+		client, clientErr           = bigtable.NewClient(ctx, conf.Project, conf.Instance, clientOptions(conf)...)
 	)
 	if adminClientErr != nil || clientErr != nil {
-		log.Fatalf("admin client error: %v\nclient error: %v", adminClientErr, clientErr)
+		return fmt.Errorf("admin client error: %v\nclient error: %v", adminClientErr, clientErr)
 	}
 	defer func() {
 		if adminClient != nil {
@@ -70,39 +157,489 @@ func main() {
 		}
 	}()
 
-	if err := createTable(ctx, adminClient, conf.Table); err != nil {
-		log.Fatalf(err.Error())
+	if conf.ReuseTable {
+		if err := preflight(ctx, adminClient, client.Open(conf.Table), conf); err != nil {
+			return err
+		}
+	} else {
+		setup := new(latencies)
+		if err := createTable(ctx, adminClient, conf, setup); err != nil {
+			return err
+		}
+		log.Printf("Setup latency: %v", setup)
+		defer func() {
+			teardown := new(latencies)
+			if cerr := cleanup(ctx, adminClient, conf, teardown); cerr != nil {
+				log.Printf("Error cleaning up: %v", cerr)
+				// the error of the test takes precedence.
+				if err == nil {
+					err = cerr
+				}
+			}
+			log.Printf("Teardown latency: %v", teardown)
+		}()
 	}
-	defer deleteTable(ctx, adminClient, conf.Table)
 
-	table := client.Open(conf.Table)
+	var (
+		reads    readHits
+		table    = client.Open(conf.Table)
+		clock    = newVersionClock(conf.VersionsPerKey)
+		readRows = readPoint
+		check    = newQualifierCheck(conf)
+		// reads are also recorded by whether they read as of -read_staleness.
+		stale, latest stats.Recorder
+		// scans are also recorded until the first row and until all rows are
+		// read.
+		stream stats.StreamStats
+	)
+	if conf.ReadMode == "scan" {
+		readRows = readScan
+	}
 	var (
 		readFunc = func(ctx context.Context, id int) error {
-			_, err := table.ReadRow(context.Background(), fmt.Sprintf("row%d", id), bigtable.RowFilter(bigtable.LatestNFilter(1)))
-			return err
+			var (
+				start = time.Now()
+				asOf  time.Time
+			)
+			if conf.ReadStaleness > 0 && rand.Intn(100) < conf.StaleReadPercent {
+				asOf = start.Add(-conf.ReadStaleness)
+			}
+			items, err := readRows(ctx, table, conf, id, asOf, &stream)
+			if conf.ReadStaleness > 0 {
+				if asOf.IsZero() {
+					latest.Record(err == nil, time.Since(start))
+				} else {
+					stale.Record(err == nil, time.Since(start))
+				}
+			}
+			if err != nil {
+				return err
+			}
+			reads.record(items, time.Since(start))
+			values, err := decodeItems(ctx, codec, items)
+			if err != nil {
+				return err
+			}
+			if check != nil && len(items) > 0 {
+				return check.verify(id, values)
+			}
+			return nil
 		}
 		writeFunc = func(ctx context.Context, id int) error {
-			mut := bigtable.NewMutation()
-			mut.Set("value", "col", bigtable.Now(), bytes.Repeat([]byte("0"), 1<<10))
-			return table.Apply(context.Background(), fmt.Sprintf("row%d", id), mut)
+			buf := gen.Get(id)
+			defer gen.Put(buf)
+			key := fmt.Sprintf("row%d", id)
+			return writeRow(ctx, table, conf, codec, check, id, key, clock.next(key), buf)
 		}
 	)
 
+	sts.Heartbeat = func(ctx context.Context) error {
+		_, err := table.ReadRow(ctx, "heartbeat")
+		return err
+	}
+	sts.AppendHistory = history.Append
+	sts.Sweep = func(ctx context.Context, id int) error {
+		row, err := table.ReadRow(ctx, fmt.Sprintf("row%d", id), readFilter(conf, time.Time{}))
+		if err != nil {
+			return err
+		}
+		if len(row[conf.Family]) == 0 {
+			return stats.ErrMissing
+		}
+		values, err := decodeItems(ctx, codec, row[conf.Family])
+		if err != nil {
+			return err
+		}
+		if check != nil {
+			return check.verify(id, values)
+		}
+		return nil
+	}
+
+	if sts.Config.MultiRun() {
+		runs, err := sts.StartRuns(readFunc, writeFunc)
+		if err != nil {
+			return err
+		}
+		log.Printf("Comparison:\n%v", stats.Compare(runs))
+		if v := stats.Verdict(runs); v != "" {
+			log.Printf("Verdict:\n%v", v)
+		}
+		if err := sts.WriteManifest(); err != nil {
+			log.Printf("Error writing manifest: %v", err)
+		}
+		return nil
+	}
+
 	read, write, err := sts.Start(readFunc, writeFunc)
 	if err != nil {
-		log.Fatalf(err.Error())
+		return err
 	}
-	log.Printf("Reads (%d ok / %d tries):\n%v", read.Ok, read.Tries, read.Aggregate())
+	log.Printf("Reads [%s] (%d ok / %d tries):\n%v", conf.ReadMode, read.Ok, read.Tries, read.Aggregate())
 	log.Printf("Writes (%d ok / %d tries):\n%v", write.Ok, write.Tries, write.Aggregate())
+	if sts.GC != nil {
+		log.Printf("GC:\n%v", sts.GC)
+	}
+	log.Printf("Read hits (%d):\n%v", reads.hits.Tries, reads.hits.Aggregate())
+	log.Printf("Read misses (%d):\n%v", reads.misses.Tries, reads.misses.Aggregate())
+	if conf.ReadStaleness > 0 {
+		log.Printf("Reads as of %v ago (%d ok / %d tries):\n%v", conf.ReadStaleness, stale.Ok, stale.Tries, stale.Aggregate())
+		log.Printf("Reads of latest (%d ok / %d tries):\n%v", latest.Ok, latest.Tries, latest.Aggregate())
+	}
+	if conf.ReadMode == "scan" {
+		log.Printf("Scan first row (%d):\n%v", stream.FirstByte.Tries, stream.FirstByte.Aggregate())
+		log.Printf("Scan complete (%d ok / %d tries):\n%v", stream.Complete.Ok, stream.Complete.Tries, stream.Complete.Aggregate())
+	}
+	if check != nil {
+		log.Printf("Qualifiers: %v", check)
+	}
+	if codec.Enabled() {
+		log.Printf("Compress (%d ok / %d tries):\n%v", codec.Compress.Ok, codec.Compress.Tries, codec.Compress.Aggregate())
+		log.Printf("Decompress (%d ok / %d tries):\n%v", codec.Decompress.Ok, codec.Decompress.Tries, codec.Decompress.Aggregate())
+	}
+	if err := sts.WriteManifest(); err != nil {
+		log.Printf("Error writing manifest: %v", err)
+	}
+	return nil
+}
+
+// clientOptions returns options to tune gRPC connections of the data client.
+func clientOptions(conf *config) []option.ClientOption {
+	var opts []option.ClientOption
+	if conf.GRPCPoolSize > 0 {
+		opts = append(opts, option.WithGRPCConnectionPool(conf.GRPCPoolSize))
+	}
+	if conf.KeepaliveTime > 0 {
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                conf.KeepaliveTime,
+			Timeout:             conf.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		})))
+	}
+	return opts
+}
+
+// decodeItems returns decoded values of items keyed by column.
+func decodeItems(ctx context.Context, codec *payload.Codec, items []bigtable.ReadItem) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(items))
+	for _, item := range items {
+		value, err := codec.Decode(ctx, item.Value)
+		if err != nil {
+			return nil, err
+		}
+		values[item.Column] = value
+	}
+	return values, nil
+}
+
+// readFilter returns the filter of the latest versions of the qualifiers. if
+// asOf is set, versions at or after asOf are filtered out, so the latest
+// versions as of asOf are read.
+func readFilter(conf *config, asOf time.Time) bigtable.ReadOption {
+	column := regexp.QuoteMeta(conf.Qualifier)
+	if conf.Qualifiers > 1 {
+		column += `[0-9]+`
+	}
+	filters := []bigtable.Filter{
+		bigtable.FamilyFilter(regexp.QuoteMeta(conf.Family)),
+		bigtable.ColumnFilter(column),
+	}
+	if !asOf.IsZero() {
+		filters = append(filters, bigtable.TimestampRangeFilter(time.Time{}, asOf))
+	}
+	filters = append(filters, bigtable.LatestNFilter(1))
+	return bigtable.RowFilter(bigtable.ChainFilters(filters...))
+}
+
+// writeRow writes buf to the row key of id by Apply. the values of the
+// qualifiers are derived from buf if check is set.
+func writeRow(ctx context.Context, table *bigtable.Table, conf *config, codec *payload.Codec, check *qualifierCheck, id int, key string, ts bigtable.Timestamp, buf []byte) error {
+	mut := bigtable.NewMutation()
+	if check == nil {
+		value, err := codec.Encode(ctx, buf)
+		if err != nil {
+			return err
+		}
+		mut.Set(conf.Family, conf.Qualifier, ts, value)
+	} else {
+		for _, q := range check.qualifiers {
+			value, err := codec.Encode(ctx, check.value(id, q, buf))
+			if err != nil {
+				return err
+			}
+			mut.Set(conf.Family, q, ts, value)
+		}
+	}
+	return table.Apply(ctx, key, mut)
+}
+
+// readPoint reads the row of id by ReadRow.
+func readPoint(ctx context.Context, table *bigtable.Table, conf *config, id int, asOf time.Time, _ *stats.StreamStats) ([]bigtable.ReadItem, error) {
+	row, err := table.ReadRow(ctx, fmt.Sprintf("row%d", id), readFilter(conf, asOf))
+	if err != nil {
+		return nil, err
+	}
+	return row[conf.Family], nil
+}
+
+// readScan reads rows by ReadRows. rows with the scan prefix are read if it is
+// set, otherwise rows from the row of id are read. the scan is timed until
+// the first row and until all rows are read to stream.
+func readScan(ctx context.Context, table *bigtable.Table, conf *config, id int, asOf time.Time, stream *stats.StreamStats) ([]bigtable.ReadItem, error) {
+	var (
+		items []bigtable.ReadItem
+		rows  bigtable.RowSet = bigtable.InfiniteRange(fmt.Sprintf("row%d", id))
+		timer                 = stream.Start()
+	)
+	if conf.ScanPrefix != "" {
+		rows = bigtable.PrefixRange(conf.ScanPrefix)
+	}
+	err := table.ReadRows(ctx, rows, func(row bigtable.Row) bool {
+		timer.Chunk()
+		items = append(items, row[conf.Family]...)
+		return true
+	}, readFilter(conf, asOf), bigtable.LimitRows(int64(conf.ScanLimit)))
+	timer.Done(err)
+	return items, err
 }
 
-func createTable(ctx context.Context, client *bigtable.AdminClient, table string) error {
-	if err := client.CreateTable(ctx, table); err != nil {
+func createTable(ctx context.Context, client *bigtable.AdminClient, conf *config, lat *latencies) error {
+	if err := lat.time("CreateTable", func() error {
+		return retryAdmin(conf.AdminRetries, func() error {
+			return client.CreateTable(ctx, conf.Table)
+		})
+	}); err != nil {
+		return err
+	}
+	if err := lat.time("CreateColumnFamily", func() error {
+		return retryAdmin(conf.AdminRetries, func() error {
+			return client.CreateColumnFamily(ctx, conf.Table, conf.Family)
+		})
+	}); err != nil {
 		return err
 	}
-	return client.CreateColumnFamily(ctx, table, "value")
+	if conf.GCMaxVersions == 0 {
+		return nil
+	}
+	return lat.time("SetGCPolicy", func() error {
+		return retryAdmin(conf.AdminRetries, func() error {
+			return client.SetGCPolicy(ctx, conf.Table, conf.Family, bigtable.MaxVersionsPolicy(conf.GCMaxVersions))
+		})
+	})
+}
+
+// preflight checks the existing table reused by -reuse_table has the column
+// family, and logs its GC policy. since qualifiers aren't a part of the schema,
+// a missing qualifier is only logged.
+func preflight(ctx context.Context, client *bigtable.AdminClient, table *bigtable.Table, conf *config) error {
+	info, err := client.TableInfo(ctx, conf.Table)
+	if err != nil {
+		return fmt.Errorf("reuse table %s: %v", conf.Table, err)
+	}
+	var (
+		family   *bigtable.FamilyInfo
+		families []string
+	)
+	for i, f := range info.FamilyInfos {
+		if f.Name == conf.Family {
+			family = &info.FamilyInfos[i]
+		}
+		families = append(families, f.Name)
+	}
+	if family == nil {
+		return fmt.Errorf("reuse table %s: no column family %s in [%s]", conf.Table, conf.Family, strings.Join(families, ", "))
+	}
+	log.Printf("Reusing table %s: column family %s has GC policy %q", conf.Table, family.Name, family.GCPolicy)
+
+	var found bool
+	if err := table.ReadRows(ctx, bigtable.InfiniteRange(""), func(bigtable.Row) bool {
+		found = true
+		return false
+	}, readFilter(conf, time.Time{}), bigtable.LimitRows(1)); err != nil {
+		return fmt.Errorf("reuse table %s: %v", conf.Table, err)
+	}
+	if !found {
+		log.Printf("Reusing table %s: no cells of %s:%s yet", conf.Table, conf.Family, conf.Qualifier)
+	}
+	return nil
+}
+
+// latencies records latencies of admin operations in order.
+type latencies struct {
+	names     []string
+	durations []time.Duration
+}
+
+func (l *latencies) time(name string, f func() error) error {
+	start := time.Now()
+	err := f()
+	l.names = append(l.names, name)
+	l.durations = append(l.durations, time.Since(start))
+	return err
+}
+
+func (l *latencies) String() string {
+	var (
+		total time.Duration
+		ops   []string
+	)
+	for i, d := range l.durations {
+		total += d
+		ops = append(ops, fmt.Sprintf("%s: %v", l.names[i], d))
+	}
+	return fmt.Sprintf("%v (%s)", total, strings.Join(ops, ", "))
+}
+
+// adminBackoff is the backoff before the first retry of admin operations.
+var adminBackoff = 500 * time.Millisecond
+
+// retryAdmin calls f until it succeeds or fails with a non retryable error,
+// with exponential backoff up to retries times.
+// AlreadyExists on a retry is treated as success, since the previous attempt
+// may have been applied even though it failed.
+func retryAdmin(retries int, f func() error) error {
+	backoff := adminBackoff
+	for i := 0; ; i++ {
+		err := f()
+		if i > 0 && status.Code(err) == codes.AlreadyExists {
+			return nil
+		}
+		if err == nil || i >= retries || !retryableAdminError(err) {
+			return err
+		}
+		log.Printf("Retrying admin operation in %v: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func retryableAdminError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// readHits records reads by whether the row exists or not, since reading an
+// empty row is cheaper than reading a populated one.
+type readHits struct {
+	hits, misses stats.Recorder
+}
+
+func (r *readHits) record(items []bigtable.ReadItem, d time.Duration) {
+	if len(items) == 0 {
+		r.misses.Record(true, d)
+		return
+	}
+	r.hits.Record(true, d)
 }
 
 func deleteTable(ctx context.Context, client *bigtable.AdminClient, table string) error {
 	return client.DeleteTable(ctx, table)
 }
+
+// cleanup deletes the table, and verifies it is deleted if -verify_cleanup is set.
+func cleanup(ctx context.Context, client *bigtable.AdminClient, conf *config, lat *latencies) error {
+	err := lat.time("DeleteTable", func() error {
+		return deleteTable(ctx, client, conf.Table)
+	})
+	if !conf.VerifyCleanup {
+		return err
+	}
+	if err != nil {
+		log.Printf("Error deleting table %s: %v", conf.Table, err)
+	}
+	tables, verr := client.Tables(ctx)
+	if verr != nil {
+		return fmt.Errorf("verify cleanup: %v", verr)
+	}
+	for _, table := range tables {
+		if table == conf.Table {
+			return fmt.Errorf("verify cleanup: table %s is left behind", conf.Table)
+		}
+	}
+	return err
+}
+
+// qualifierCheck writes known values to multiple qualifiers of a row, and
+// verifies the values read back.
+type qualifierCheck struct {
+	family     string
+	qualifiers []string
+
+	verified   int64
+	mismatched int64
+}
+
+// newQualifierCheck returns nil unless -qualifiers is more than 1.
+func newQualifierCheck(conf *config) *qualifierCheck {
+	if conf.Qualifiers <= 1 {
+		return nil
+	}
+	c := &qualifierCheck{family: conf.Family}
+	for i := 0; i < conf.Qualifiers; i++ {
+		c.qualifiers = append(c.qualifiers, fmt.Sprintf("%s%d", conf.Qualifier, i))
+	}
+	return c
+}
+
+// value returns the known value of the qualifier of the row of id, which is
+// the payload prefixed with the row and the qualifier.
+func (c *qualifierCheck) value(id int, qualifier string, payload []byte) []byte {
+	tag := fmt.Sprintf("row%d/%s:", id, qualifier)
+	return append([]byte(tag), payload...)
+}
+
+// verify checks decoded values of the row of id keyed by column, and returns
+// an error for the first qualifier which is missing or has an unexpected
+// value. each qualifier is counted as verified or mismatched.
+func (c *qualifierCheck) verify(id int, values map[string][]byte) error {
+	var first error
+	for _, q := range c.qualifiers {
+		value, ok := values[c.family+":"+q]
+		if ok && bytes.HasPrefix(value, []byte(fmt.Sprintf("row%d/%s:", id, q))) {
+			atomic.AddInt64(&c.verified, 1)
+			continue
+		}
+		atomic.AddInt64(&c.mismatched, 1)
+		if first == nil {
+			first = fmt.Errorf("qualifier %s of row%d mismatched", q, id)
+		}
+	}
+	return first
+}
+
+func (c *qualifierCheck) String() string {
+	return fmt.Sprintf("%d verified, %d mismatched", atomic.LoadInt64(&c.verified), atomic.LoadInt64(&c.mismatched))
+}
+
+// versionClock gives cell timestamps to writes, so that writes to a key cycle
+// through versions distinct timestamps and the versions accumulate on the key.
+type versionClock struct {
+	mu       sync.Mutex
+	versions int
+	base     bigtable.Timestamp
+	writes   map[string]int
+}
+
+func newVersionClock(versions int) *versionClock {
+	return &versionClock{
+		versions: versions,
+		base:     bigtable.Now().TruncateToMilliseconds(),
+		writes:   make(map[string]int),
+	}
+}
+
+func (v *versionClock) next(key string) bigtable.Timestamp {
+	if v.versions == 0 {
+		return bigtable.Now()
+	}
+	v.mu.Lock()
+	n := v.writes[key]
+	v.writes[key]++
+	v.mu.Unlock()
+	// bigtable timestamps are microseconds with millisecond granularity.
+	return v.base + bigtable.Timestamp(n%v.versions*1000)
+}
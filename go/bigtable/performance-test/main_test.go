@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	"cloud.google.com/go/bigtable/bttest"
+	"google.golang.org/api/option"
+	btpb "google.golang.org/genproto/googleapis/bigtable/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ryutah/gcp-sample/go/internal/payload"
+	"github.com/ryutah/gcp-sample/go/internal/stats"
+)
+
+// newTestClients returns clients of an in-memory Bigtable server, connected
+// with opts.
+func newTestClients(t *testing.T, conf *config, opts ...grpc.DialOption) (*bigtable.AdminClient, *bigtable.Client) {
+	t.Helper()
+	srv, err := bttest.NewServer("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	conn, err := grpc.Dial(srv.Addr, append(opts, grpc.WithInsecure())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ctx := context.Background()
+	admin, err := bigtable.NewAdminClient(ctx, conf.Project, conf.Instance, option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := bigtable.NewClient(ctx, conf.Project, conf.Instance, option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return admin, client
+}
+
+// newTestConfig returns a config of custom family and qualifier names.
+func newTestConfig() *config {
+	return &config{
+		Table:     "scratch",
+		Project:   "project",
+		Instance:  "instance",
+		Family:    "fam",
+		Qualifier: "qual",
+	}
+}
+
+// writeTestRow writes value to the row key without compression.
+func writeTestRow(ctx context.Context, table *bigtable.Table, conf *config, key string, ts bigtable.Timestamp, value []byte) error {
+	return writeRow(ctx, table, conf, payload.NewCodec(payload.NewConfig()), nil, 0, key, ts, value)
+}
+
+func TestFamilyAndQualifier(t *testing.T) {
+	var (
+		ctx           = context.Background()
+		conf          = newTestConfig()
+		admin, client = newTestClients(t, conf)
+	)
+	if err := createTable(ctx, admin, conf, new(latencies)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := admin.TableInfo(ctx, conf.Table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Families) != 1 || info.Families[0] != conf.Family {
+		t.Errorf("families = %v, want [%s]", info.Families, conf.Family)
+	}
+
+	table := client.Open(conf.Table)
+	if err := writeTestRow(ctx, table, conf, "row1", bigtable.Now(), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	// a cell of another qualifier isn't read.
+	mut := bigtable.NewMutation()
+	mut.Set(conf.Family, "other", bigtable.Now(), []byte("other"))
+	if err := table.Apply(ctx, "row1", mut); err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := table.ReadRow(ctx, "row1", readFilter(conf, time.Time{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := row[conf.Family]
+	if len(items) != 1 || items[0].Column != conf.Family+":"+conf.Qualifier || string(items[0].Value) != "value" {
+		t.Errorf("read items = %+v, want the value written to %s:%s", items, conf.Family, conf.Qualifier)
+	}
+}
+
+// failCreateTable fails the first n calls of CreateTable with code. the calls
+// are applied before failing if apply is set, as if only the response is lost.
+func failCreateTable(n int, code codes.Code, apply bool, calls *int) grpc.DialOption {
+	return grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if method != "/google.bigtable.admin.v2.BigtableTableAdmin/CreateTable" {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		*calls++
+		if *calls > n {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if apply {
+			if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+				return err
+			}
+		}
+		return status.Error(code, "injected")
+	})
+}
+
+func TestCreateTableRetry(t *testing.T) {
+	saved := adminBackoff
+	defer func() { adminBackoff = saved }()
+	adminBackoff = time.Millisecond
+
+	tests := []struct {
+		name      string
+		fails     int
+		code      codes.Code
+		apply     bool
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "unavailable once", fails: 1, code: codes.Unavailable, wantCalls: 2},
+		{name: "applied but deadline exceeded", fails: 1, code: codes.DeadlineExceeded, apply: true, wantCalls: 2},
+		{name: "retries exhausted", fails: 3, code: codes.Unavailable, wantCalls: 3, wantErr: true},
+		{name: "not retryable", fails: 1, code: codes.PermissionDenied, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				ctx      = context.Background()
+				conf     = newTestConfig()
+				calls    int
+				admin, _ = newTestClients(t, conf, failCreateTable(tt.fails, tt.code, tt.apply, &calls))
+			)
+			conf.AdminRetries = 2
+			err := createTable(ctx, admin, conf, new(latencies))
+			if (err != nil) != tt.wantErr || calls != tt.wantCalls {
+				t.Fatalf("createTable() = %v after %d calls, want error %v after %d calls", err, calls, tt.wantErr, tt.wantCalls)
+			}
+			if tt.wantErr {
+				return
+			}
+			info, err := admin.TableInfo(ctx, conf.Table)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(info.Families) != 1 || info.Families[0] != conf.Family {
+				t.Errorf("families = %v, want [%s]", info.Families, conf.Family)
+			}
+		})
+	}
+}
+
+func TestVersionsPerKey(t *testing.T) {
+	const versions = 3
+	var (
+		ctx           = context.Background()
+		conf          = newTestConfig()
+		admin, client = newTestClients(t, conf)
+	)
+	conf.VersionsPerKey = versions
+	conf.GCMaxVersions = versions
+	if err := createTable(ctx, admin, conf, new(latencies)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := admin.TableInfo(ctx, conf.Table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.FamilyInfos) != 1 || info.FamilyInfos[0].GCPolicy == "" {
+		t.Errorf("family infos = %+v, want a max versions GC policy", info.FamilyInfos)
+	}
+
+	var (
+		table = client.Open(conf.Table)
+		clock = newVersionClock(conf.VersionsPerKey)
+	)
+	// writes past -versions_per_key overwrite the oldest timestamps.
+	for i := 0; i < versions+2; i++ {
+		if err := writeTestRow(ctx, table, conf, "row1", clock.next("row1"), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	row, err := table.ReadRow(ctx, "row1", bigtable.RowFilter(bigtable.LatestNFilter(versions+2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := row[conf.Family]
+	if len(items) != versions {
+		t.Fatalf("read %d versions, want %d", len(items), versions)
+	}
+	seen := make(map[bigtable.Timestamp]bool)
+	for _, item := range items {
+		seen[item.Timestamp] = true
+	}
+	if len(seen) != versions {
+		t.Errorf("timestamps = %v, want %d distinct", seen, versions)
+	}
+}
+
+func TestReadHits(t *testing.T) {
+	var (
+		ctx           = context.Background()
+		conf          = newTestConfig()
+		admin, client = newTestClients(t, conf)
+	)
+	if err := createTable(ctx, admin, conf, new(latencies)); err != nil {
+		t.Fatal(err)
+	}
+	table := client.Open(conf.Table)
+	for _, key := range []string{"row1", "row3"} {
+		if err := writeTestRow(ctx, table, conf, key, bigtable.Now(), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var reads readHits
+	for _, key := range []string{"row1", "row2", "row3", "row4", "row5"} {
+		row, err := table.ReadRow(ctx, key, readFilter(conf, time.Time{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reads.record(row[conf.Family], time.Millisecond)
+	}
+	if reads.hits.Tries != 2 || reads.misses.Tries != 3 {
+		t.Errorf("hits = %d, misses = %d, want 2 and 3", reads.hits.Tries, reads.misses.Tries)
+	}
+}
+
+// recordReadRows records requests of ReadRows calls to reqs.
+func recordReadRows(reqs *[]*btpb.ReadRowsRequest) grpc.DialOption {
+	return grpc.WithStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil || method != "/google.bigtable.v2.Bigtable/ReadRows" {
+			return cs, err
+		}
+		return &recordingStream{ClientStream: cs, reqs: reqs}, nil
+	})
+}
+
+type recordingStream struct {
+	grpc.ClientStream
+	reqs *[]*btpb.ReadRowsRequest
+}
+
+func (s *recordingStream) SendMsg(m interface{}) error {
+	if req, ok := m.(*btpb.ReadRowsRequest); ok {
+		*s.reqs = append(*s.reqs, req)
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func TestReadMode(t *testing.T) {
+	var (
+		ctx           = context.Background()
+		conf          = newTestConfig()
+		reqs          []*btpb.ReadRowsRequest
+		admin, client = newTestClients(t, conf, recordReadRows(&reqs))
+	)
+	conf.ScanLimit = 10
+	if err := createTable(ctx, admin, conf, new(latencies)); err != nil {
+		t.Fatal(err)
+	}
+	table := client.Open(conf.Table)
+	for _, key := range []string{"row1", "row2", "row3"} {
+		if err := writeTestRow(ctx, table, conf, key, bigtable.Now(), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		read      func(ctx context.Context, table *bigtable.Table, conf *config, id int, asOf time.Time, stream *stats.StreamStats) ([]bigtable.ReadItem, error)
+		prefix    string
+		wantItems int
+		wantScan  bool
+	}{
+		{name: "point", read: readPoint, wantItems: 1},
+		{name: "scan", read: readScan, wantItems: 2, wantScan: true},
+		{name: "scan prefix", read: readScan, prefix: "row1", wantItems: 1, wantScan: true},
+	}
+	for _, tt := range tests {
+		reqs = nil
+		conf.ScanPrefix = tt.prefix
+		items, err := tt.read(ctx, table, conf, 2, time.Time{}, new(stats.StreamStats))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != tt.wantItems {
+			t.Errorf("%s: read %d items, want %d", tt.name, len(items), tt.wantItems)
+		}
+		if len(reqs) != 1 {
+			t.Fatalf("%s: %d ReadRows calls, want 1", tt.name, len(reqs))
+		}
+		var (
+			rows = reqs[0].GetRows()
+			scan = len(rows.GetRowRanges()) == 1 && len(rows.GetRowKeys()) == 0 && reqs[0].GetRowsLimit() == int64(conf.ScanLimit)
+			read = len(rows.GetRowRanges()) == 0 && len(rows.GetRowKeys()) == 1 && reqs[0].GetRowsLimit() == 0
+		)
+		if tt.wantScan && !scan || !tt.wantScan && !read {
+			t.Errorf("%s: ReadRows request = %v, want a scan %v", tt.name, reqs[0], tt.wantScan)
+		}
+	}
+}
+
+func TestSetupLatency(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		conf     = newTestConfig()
+		admin, _ = newTestClients(t, conf)
+		setup    = new(latencies)
+		teardown = new(latencies)
+	)
+	conf.GCMaxVersions = 2
+	if err := createTable(ctx, admin, conf, setup); err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanup(ctx, admin, conf, teardown); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		lat   *latencies
+		names []string
+	}{
+		{lat: setup, names: []string{"CreateTable", "CreateColumnFamily", "SetGCPolicy"}},
+		{lat: teardown, names: []string{"DeleteTable"}},
+	} {
+		if !reflect.DeepEqual(tt.lat.names, tt.names) {
+			t.Errorf("timed %v, want %v", tt.lat.names, tt.names)
+		}
+		for i, d := range tt.lat.durations {
+			if d <= 0 {
+				t.Errorf("%s took %v, want a positive duration", tt.lat.names[i], d)
+			}
+		}
+		report := tt.lat.String()
+		for _, name := range tt.names {
+			if !strings.Contains(report, name+": ") {
+				t.Errorf("report %q doesn't have %s", report, name)
+			}
+		}
+	}
+}
+
+func TestQualifiers(t *testing.T) {
+	var (
+		ctx           = context.Background()
+		conf          = newTestConfig()
+		admin, client = newTestClients(t, conf)
+		codec         = payload.NewCodec(payload.NewConfig())
+	)
+	conf.Qualifiers = 3
+	conf.ReadMode = "point"
+	if err := createTable(ctx, admin, conf, new(latencies)); err != nil {
+		t.Fatal(err)
+	}
+	table := client.Open(conf.Table)
+	check := newQualifierCheck(conf)
+	if err := writeRow(ctx, table, conf, codec, check, 1, "row1", bigtable.Now(), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := readPoint(ctx, table, conf, 1, time.Time{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string][]byte, len(items))
+	for _, item := range items {
+		values[item.Column] = item.Value
+	}
+	if len(values) != conf.Qualifiers {
+		t.Fatalf("read %d qualifiers, want %d", len(values), conf.Qualifiers)
+	}
+	if err := check.verify(1, values); err != nil {
+		t.Errorf("verify() = %v, want the written values verified", err)
+	}
+	// values of another row don't match.
+	if err := check.verify(2, values); err == nil {
+		t.Error("verify() of another row = nil, want mismatched")
+	}
+	if got, want := check.String(), "3 verified, 3 mismatched"; got != want {
+		t.Errorf("check = %q, want %q", got, want)
+	}
+}
+
+func TestReadStaleness(t *testing.T) {
+	var (
+		ctx           = context.Background()
+		conf          = newTestConfig()
+		admin, client = newTestClients(t, conf)
+		now           = time.Now()
+	)
+	if err := createTable(ctx, admin, conf, new(latencies)); err != nil {
+		t.Fatal(err)
+	}
+	table := client.Open(conf.Table)
+	for _, cell := range []struct {
+		at    time.Time
+		value string
+	}{
+		{at: now.Add(-time.Minute), value: "old"},
+		{at: now, value: "new"},
+	} {
+		if err := writeTestRow(ctx, table, conf, "row1", bigtable.Time(cell.at), []byte(cell.value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		asOf time.Time
+		want string
+	}{
+		{name: "latest", want: "new"},
+		{name: "stale", asOf: now.Add(-time.Second), want: "old"},
+	}
+	for _, tt := range tests {
+		items, err := readPoint(ctx, table, conf, 1, tt.asOf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 || string(items[0].Value) != tt.want {
+			t.Errorf("%s: read %v, want %q", tt.name, items, tt.want)
+		}
+	}
+}
+
+// stallData returns options which stall calls of the data API until their
+// context is done, as if the server were slow.
+func stallData() []grpc.DialOption {
+	stall := func(ctx context.Context, method string) error {
+		if !strings.HasPrefix(method, "/google.bigtable.v2.Bigtable/") {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Second):
+			return nil
+		}
+	}
+	return []grpc.DialOption{
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			if err := stall(ctx, method); err != nil {
+				return err
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+		grpc.WithStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			if err := stall(ctx, method); err != nil {
+				return nil, err
+			}
+			return streamer(ctx, desc, cc, method, opts...)
+		}),
+	}
+}
+
+func TestOpsAbortOnCancel(t *testing.T) {
+	var (
+		conf          = newTestConfig()
+		admin, client = newTestClients(t, conf, stallData()...)
+		codec         = payload.NewCodec(payload.NewConfig())
+	)
+	conf.ScanLimit = 10
+	if err := createTable(context.Background(), admin, conf, new(latencies)); err != nil {
+		t.Fatal(err)
+	}
+	table := client.Open(conf.Table)
+
+	ops := map[string]func(ctx context.Context) error{
+		"writeRow": func(ctx context.Context) error {
+			return writeRow(ctx, table, conf, codec, nil, 1, "row1", bigtable.Now(), []byte("value"))
+		},
+		"readPoint": func(ctx context.Context) error {
+			_, err := readPoint(ctx, table, conf, 1, time.Time{}, nil)
+			return err
+		},
+		"readScan": func(ctx context.Context) error {
+			_, err := readScan(ctx, table, conf, 1, time.Time{}, new(stats.StreamStats))
+			return err
+		},
+	}
+	for name, op := range ops {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		start := time.Now()
+		err := op(ctx)
+		if status.Code(err) != codes.Canceled && err != context.Canceled {
+			t.Errorf("%s() cancelled while in flight = %v, want Canceled", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s() returned %v after the cancel, want promptly", name, elapsed)
+		}
+	}
+}
+
+func TestOpsWithCancelledContext(t *testing.T) {
+	var (
+		conf          = newTestConfig()
+		admin, client = newTestClients(t, conf)
+	)
+	if err := createTable(context.Background(), admin, conf, new(latencies)); err != nil {
+		t.Fatal(err)
+	}
+	table := client.Open(conf.Table)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := writeTestRow(ctx, table, conf, "row1", bigtable.Now(), []byte("value")); status.Code(err) != codes.Canceled && err != context.Canceled {
+		t.Errorf("writeRow() with a cancelled ctx = %v, want Canceled", err)
+	}
+	if _, err := readPoint(ctx, table, conf, 1, time.Time{}, nil); status.Code(err) != codes.Canceled && err != context.Canceled {
+		t.Errorf("readPoint() with a cancelled ctx = %v, want Canceled", err)
+	}
+	// the write isn't executed.
+	items, err := readPoint(context.Background(), table, conf, 1, time.Time{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("read %v, want the row not written", items)
+	}
+}
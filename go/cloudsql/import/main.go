@@ -4,13 +4,23 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 
+	"cloud.google.com/go/storage"
 	"golang.org/x/oauth2/google"
 	sqladmin "google.golang.org/api/sqladmin/v1beta4"
+
+	"github.com/ryutah/gcp-sample/go/internal/stats"
 )
 
 var (
@@ -18,11 +28,76 @@ var (
 	instanceName   = "[INSTANCE_NAME]"
 	bucket         = "[BUCKET]"
 	connectionName = "[CONNECTION_NAME]"
+	objectName     = "sample.csv"
 )
 
+// placeholderPattern matches the placeholder values such as [PROJECT_ID].
+var placeholderPattern = regexp.MustCompile(`^\[.*\]$`)
+
+// mergeQueries are run after the import to merge foo_temp into foo.
+var mergeQueries = []string{
+	"insert into foo(id, value) select * from foo_temp on duplicate key update value = values(value)",
+	"truncate table foo_temp;",
+}
+
 func main() {
+	var (
+		dryRun       bool
+		localFile    string
+		keepUpload   bool
+		pollInterval time.Duration
+		pollJitter   time.Duration
+		expectRows   int
+		readWhile    bool
+		sConf        = stats.NewConfig()
+	)
+	// reads during the import are light and last until the import is done.
+	sConf.RunFor = time.Hour
+	sConf.ReqCount = 4
+	sConf.WritePercent = 0
+	sConf.RegisterFlagsOf("run_for", "req_count")
+	flag.StringVar(&projectID, "project", projectID, "GCP project ID")
+	flag.StringVar(&instanceName, "instance", instanceName, "Cloud SQL instance name")
+	flag.StringVar(&bucket, "bucket", bucket, "GCS bucket which has the CSV to import")
+	flag.StringVar(&objectName, "object", objectName, "GCS object of the CSV to import")
+	flag.StringVar(&connectionName, "conn", connectionName, "DSN of the database to merge into")
+	flag.BoolVar(&dryRun, "dry_run", false, "import only and skip merging foo_temp into foo")
+	flag.StringVar(&localFile, "local_file", "", "local CSV to upload to -bucket as -object before the import")
+	flag.BoolVar(&keepUpload, "keep_upload", false, "keep the object uploaded from -local_file after the import")
+	flag.DurationVar(&pollInterval, "poll_interval", time.Second, "interval of polling the import operation")
+	flag.DurationVar(&pollJitter, "poll_jitter", 0, "max random deviation added to -poll_interval to avoid synchronized polling")
+	flag.IntVar(&expectRows, "expect_rows", -1, "number of rows expected to be imported into foo_temp; negative to skip the check")
+	flag.BoolVar(&readWhile, "read_while_import", false, "run reads on foo while the import is in progress, and report their latency; -run_for bounds the reads")
+	if err := sConf.ParseFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := checkPlaceholders(flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// seed for -poll_jitter, or concurrent imports would poll in sync.
+	rand.Seed(time.Now().UnixNano())
 	ctx := context.Background()
 
+	var obj *storage.ObjectHandle
+	if localFile != "" {
+		gcs, err := storage.NewClient(ctx)
+		if err != nil {
+			panic(err)
+		}
+		defer gcs.Close()
+		obj = gcs.Bucket(bucket).Object(objectName)
+	}
+
+	db, err := sql.Open("mysql", connectionName)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
 	client, err := google.DefaultClient(ctx)
 	if err != nil {
 		panic(err)
@@ -32,16 +107,14 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	ope, err := service.Instances.Import(projectID, instanceName, &sqladmin.InstancesImportRequest{
-		ImportContext: &sqladmin.ImportContext{
-			CsvImportOptions: &sqladmin.ImportContextCsvImportOptions{
-				Table: "foo_temp",
-			},
-			Database: "example",
-			FileType: "csv",
-			Uri:      fmt.Sprintf("gs://%s/sample.csv", bucket),
-		},
-	}).Do()
+	ope, err := startImport(ctx, service, obj, localFile)
+	if obj != nil && !keepUpload {
+		defer func() {
+			if err := obj.Delete(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to delete gs://%s/%s: %v\n", bucket, objectName, err)
+			}
+		}()
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -53,37 +126,187 @@ func main() {
 
 	time.Sleep(300 * time.Millisecond)
 
-	for {
-		resp, err := client.Get(ope.SelfLink)
+	wait := func() error {
+		return waitOperation(client, ope.SelfLink, pollInterval, pollJitter)
+	}
+	if readWhile {
+		read, err := readDuring(stats.NewStats(sConf), readFoo(db), wait)
 		if err != nil {
 			panic(err)
 		}
-		defer resp.Body.Close()
+		fmt.Printf("reads during the import (%d ok / %d tries):\n%v", read.Ok, read.Tries, read.Aggregate())
+	} else if err := wait(); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("finish!!")
 
-		payload := new(sqladmin.Operation)
-		if err := json.NewDecoder(resp.Body).Decode(payload); err != nil {
+	if expectRows >= 0 {
+		if err := checkRowCount(db, expectRows); err != nil {
 			panic(err)
 		}
-		if payload.Status == "DONE" {
-			break
+	}
+	if err := merge(db, dryRun); err != nil {
+		panic(err)
+	}
+	fmt.Println("exit...")
+}
+
+// checkPlaceholders returns an error for the first flag of fs left with a
+// placeholder value.
+func checkPlaceholders(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err == nil && placeholderPattern.MatchString(f.Value.String()) {
+			err = fmt.Errorf("-%s is left with the placeholder %s", f.Name, f.Value)
+		}
+	})
+	return err
+}
+
+// startImport starts the import of the CSV in -bucket as -object. if obj is
+// set, localFile is uploaded to obj before the import.
+func startImport(ctx context.Context, service *sqladmin.Service, obj *storage.ObjectHandle, localFile string) (*sqladmin.Operation, error) {
+	if obj != nil {
+		if err := upload(ctx, obj, localFile); err != nil {
+			return nil, err
+		}
+		fmt.Printf("uploaded %s to gs://%s/%s\n", localFile, bucket, objectName)
+	}
+	return service.Instances.Import(projectID, instanceName, &sqladmin.InstancesImportRequest{
+		ImportContext: &sqladmin.ImportContext{
+			CsvImportOptions: &sqladmin.ImportContextCsvImportOptions{
+				Table: "foo_temp",
+			},
+			Database: "example",
+			FileType: "csv",
+			Uri:      fmt.Sprintf("gs://%s/%s", bucket, objectName),
+		},
+	}).Context(ctx).Do()
+}
+
+// waitOperation polls the operation at selfLink until it's done, sleeping
+// for the interval with jitter between polls.
+func waitOperation(client *http.Client, selfLink string, interval, jitter time.Duration) error {
+	for {
+		done, err := operationDone(client, selfLink)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
 		}
 		fmt.Println("stay...")
-		time.Sleep(1 * time.Second)
+		time.Sleep(pollDelay(interval, jitter))
+	}
+}
+
+func operationDone(client *http.Client, selfLink string) (bool, error) {
+	resp, err := client.Get(selfLink)
+	if err != nil {
+		return false, err
 	}
+	defer resp.Body.Close()
 
-	fmt.Println("finish!!")
+	payload := new(sqladmin.Operation)
+	if err := json.NewDecoder(resp.Body).Decode(payload); err != nil {
+		return false, err
+	}
+	return payload.Status == "DONE", nil
+}
 
-	db, err := sql.Open("mysql", connectionName)
+// pollDelay returns interval with a random deviation within jitter.
+func pollDelay(interval, jitter time.Duration) time.Duration {
+	d := interval
+	if jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*jitter))) - jitter
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// upload streams the local file at path to obj.
+func upload(ctx context.Context, obj *storage.ObjectHandle, path string) error {
+	f, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer db.Close()
+	defer f.Close()
 
-	if _, err := db.Exec("insert into foo(id, value) select * from foo_temp on duplicate key update value = values(value)"); err != nil {
-		panic(err)
+	w := obj.NewWriter(ctx)
+	w.ContentType = "text/csv"
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
 	}
-	if _, err := db.Exec("truncate table foo_temp;"); err != nil {
-		panic(err)
+	return w.Close()
+}
+
+// readFoo returns a read of id on foo, which ignores missing rows as the
+// import may not have reached them yet.
+func readFoo(db *sql.DB) stats.StatsFunc {
+	return func(ctx context.Context, id int) error {
+		var value []byte
+		err := db.QueryRowContext(ctx, "SELECT value FROM foo WHERE id = ?", id).Scan(&value)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
 	}
-	fmt.Println("exit...")
+}
+
+// readDuring runs reads by readFunc with sts until wait returns, and returns
+// the latency of the reads. it returns the error of wait, and the failure of
+// the reads is only reported as it doesn't affect the import.
+func readDuring(sts *stats.Stats, readFunc stats.StatsFunc, wait func() error) (*stats.Recorder, error) {
+	var (
+		done   = make(chan struct{})
+		result = make(chan error, 1)
+		read   stats.Recorder
+	)
+	writeFunc := func(ctx context.Context, id int) error {
+		return errors.New("writes are not run during the import")
+	}
+
+	sts.Done = done
+	go func() {
+		var err error
+		read, _, err = sts.Start(readFunc, writeFunc)
+		result <- err
+	}()
+	err := wait()
+	close(done)
+	if rerr := <-result; rerr != nil {
+		fmt.Fprintf(os.Stderr, "reads during the import failed: %v\n", rerr)
+	}
+	return &read, err
+}
+
+// checkRowCount returns an error if foo_temp doesn't have the expected number
+// of rows, which means the import is partial.
+func checkRowCount(db *sql.DB, expected int) error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM foo_temp").Scan(&count); err != nil {
+		return err
+	}
+	if count != expected {
+		return fmt.Errorf("imported %d rows into foo_temp, expected %d", count, expected)
+	}
+	fmt.Printf("imported %d rows\n", count)
+	return nil
+}
+
+func merge(db *sql.DB, dryRun bool) error {
+	for _, query := range mergeQueries {
+		if dryRun {
+			fmt.Printf("dry run: skip %q\n", query)
+			continue
+		}
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+	sqladmin "google.golang.org/api/sqladmin/v1beta4"
+
+	"github.com/ryutah/gcp-sample/go/internal/stats"
+)
+
+func TestMerge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, query := range mergeQueries {
+		mock.ExpectExec(regexp.QuoteMeta(query)).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	if err := merge(db, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMergeDryRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// no statement is expected, so executing any fails.
+	if err := merge(db, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckPlaceholders(t *testing.T) {
+	newFlagSet := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("import", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		fs.String("project", projectID, "")
+		fs.String("instance", instanceName, "")
+		fs.Bool("dry_run", false, "")
+		return fs
+	}
+
+	fs := newFlagSet()
+	if err := fs.Parse([]string{"-instance=instance"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPlaceholders(fs); err == nil || !strings.Contains(err.Error(), "-project") {
+		t.Errorf("checkPlaceholders() = %v, want an error pointing at -project", err)
+	}
+
+	fs = newFlagSet()
+	if err := fs.Parse([]string{"-project=project", "-instance=instance"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPlaceholders(fs); err != nil {
+		t.Errorf("checkPlaceholders() = %v, want nil", err)
+	}
+}
+
+func TestMainRejectsPlaceholders(t *testing.T) {
+	if os.Getenv("IMPORT_TEST_MAIN") == "1" {
+		os.Args = []string{"import", "-instance=instance", "-bucket=bucket", "-conn=conn"}
+		main()
+		return
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMainRejectsPlaceholders$")
+	cmd.Env = append(os.Environ(), "IMPORT_TEST_MAIN=1")
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 2 {
+		t.Fatalf("main() exited by %v, want exit status 2", err)
+	}
+	// the import would panic on the first API call without credentials.
+	if out := stderr.String(); !strings.Contains(out, "-project is left with the placeholder [PROJECT_ID]") || strings.Contains(out, "panic: ") {
+		t.Errorf("stderr = %q, want -project rejected before any API call", out)
+	}
+}
+
+// fakeAPI is a fake of GCS and Cloud SQL Admin APIs, which records requests in
+// order.
+type fakeAPI struct {
+	requests []string
+	uploaded []byte
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/storage/v1/b/bucket/o"):
+		f.requests = append(f.requests, "upload")
+		f.uploaded = body
+		fmt.Fprint(w, `{"bucket": "bucket", "name": "sample.csv"}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/sql/v1beta4/projects/project/instances/instance/import":
+		f.requests = append(f.requests, "import")
+		fmt.Fprint(w, `{"name": "operation", "status": "PENDING"}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestStartImportUploadsFirst(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localFile := filepath.Join(dir, "sample.csv")
+	if err := ioutil.WriteFile(localFile, []byte("1,one\n2,two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	savedProject, savedInstance, savedBucket := projectID, instanceName, bucket
+	defer func() { projectID, instanceName, bucket = savedProject, savedInstance, savedBucket }()
+	projectID, instanceName, bucket = "project", "instance", "bucket"
+
+	var (
+		ctx  = context.Background()
+		fake = new(fakeAPI)
+		srv  = httptest.NewServer(fake)
+	)
+	defer srv.Close()
+	gcs, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gcs.Close()
+	service, err := sqladmin.New(srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.BasePath = srv.URL + "/sql/v1beta4/"
+
+	ope, err := startImport(ctx, service, gcs.Bucket(bucket).Object(objectName), localFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ope.Name != "operation" {
+		t.Errorf("operation = %+v, want the started import", ope)
+	}
+	if got := strings.Join(fake.requests, ","); got != "upload,import" {
+		t.Errorf("requests = %s, want the upload before the import", got)
+	}
+	if !bytes.Contains(fake.uploaded, []byte("1,one\n2,two\n")) {
+		t.Errorf("uploaded %q, want the local file", fake.uploaded)
+	}
+}
+
+func TestPollDelay(t *testing.T) {
+	tests := []struct {
+		interval, jitter time.Duration
+		min, max         time.Duration
+	}{
+		{interval: time.Second, jitter: 0, min: time.Second, max: time.Second},
+		{interval: time.Second, jitter: 200 * time.Millisecond, min: 800 * time.Millisecond, max: 1200 * time.Millisecond},
+		// a delay isn't negative even if the jitter is over the interval.
+		{interval: 100 * time.Millisecond, jitter: time.Second, min: 0, max: 1100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 1000; i++ {
+			if d := pollDelay(tt.interval, tt.jitter); d < tt.min || d > tt.max {
+				t.Fatalf("pollDelay(%v, %v) = %v, want within [%v, %v]", tt.interval, tt.jitter, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestWaitOperation(t *testing.T) {
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		status := "RUNNING"
+		if polls == 3 {
+			status = "DONE"
+		}
+		fmt.Fprintf(w, `{"name": "operation", "status": %q}`, status)
+	}))
+	defer srv.Close()
+
+	if err := waitOperation(srv.Client(), srv.URL, time.Millisecond, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if polls != 3 {
+		t.Errorf("polled %d times, want until the operation is done", polls)
+	}
+}
+
+func TestCheckRowCount(t *testing.T) {
+	tests := []struct {
+		count, expected int
+		wantErr         bool
+	}{
+		{count: 100, expected: 100},
+		{count: 60, expected: 100, wantErr: true},
+		{count: 0, expected: 100, wantErr: true},
+	}
+	for _, tt := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM foo_temp")).
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(tt.count))
+		err = checkRowCount(db, tt.expected)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkRowCount() of %d rows = %v, want error %v", tt.count, err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("imported %d rows into foo_temp, expected %d", tt.count, tt.expected)) {
+			t.Errorf("checkRowCount() = %v, want both counts reported", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	}
+}
+
+func TestReadDuring(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		importing bool
+		during    int
+		calls     int
+	)
+	readFunc := func(ctx context.Context, id int) error {
+		mu.Lock()
+		calls++
+		if importing {
+			during++
+		}
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		return nil
+	}
+	// the faked import runs for a while and then is done.
+	wait := func() error {
+		mu.Lock()
+		importing = true
+		mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		importing = false
+		mu.Unlock()
+		return nil
+	}
+
+	conf := stats.NewConfig()
+	conf.RunFor = time.Hour
+	conf.ReqCount = 2
+	conf.WritePercent = 0
+	read, err := readDuring(stats.NewStats(conf), readFunc, wait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if during == 0 {
+		t.Fatal("no read is issued during the import")
+	}
+	if read.Tries != calls || read.Ok != calls {
+		t.Errorf("recorded %d ok / %d tries, want all of %d reads", read.Ok, read.Tries, calls)
+	}
+	if p := read.Percentile(50); p < 2*time.Millisecond {
+		t.Errorf("median read latency = %v, want at least the read's 2ms", p)
+	}
+}
+
+func TestReadDuringWaitError(t *testing.T) {
+	conf := stats.NewConfig()
+	conf.RunFor = time.Hour
+	conf.ReqCount = 1
+	conf.WritePercent = 0
+	wantErr := errors.New("import failed")
+	readFunc := func(ctx context.Context, id int) error { return nil }
+	if _, err := readDuring(stats.NewStats(conf), readFunc, func() error { return wantErr }); err != wantErr {
+		t.Errorf("readDuring() = %v, want the error of the import", err)
+	}
+}
@@ -1,17 +1,25 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	validator "gopkg.in/go-playground/validator.v9"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/ryutah/gcp-sample/go/internal/history"
+	"github.com/ryutah/gcp-sample/go/internal/payload"
 	"github.com/ryutah/gcp-sample/go/internal/stats"
 )
 
@@ -22,6 +30,22 @@ type config struct {
 	User   string `validate:"required"`
 	Pass   string `validate:"required"`
 	Socket string `validate:"required"`
+
+	Charset         string `validate:"omitempty,oneof=utf8 utf8mb4 latin1 ascii binary"`
+	InsertOrder     string `validate:"oneof=sequential random"`
+	ReadConsistency string `validate:"oneof=autocommit repeatable_read"`
+	Databases       string
+	VerifyCleanup   bool
+	HotKeys         int `validate:"min=0"`
+	Churn           int `validate:"min=0"`
+
+	Prewarm            bool
+	PrewarmParallelism int `validate:"min=1"`
+
+	Decode bool
+
+	TxStatements string
+	TxSameKey    bool
 }
 
 func (c *config) registerFlags() {
@@ -31,110 +55,753 @@ func (c *config) registerFlags() {
 	flag.StringVar(&c.Socket, "socket", "/cloudsql", "socket file path for cloud sql")
 	flag.StringVar(&c.User, "user", "", "database user name to use")
 	flag.StringVar(&c.Pass, "pass", "", "password for user")
+	flag.StringVar(&c.Charset, "charset", "", "charset of the connection and the table; one of utf8, utf8mb4, latin1, ascii, binary. empty to use the server default")
+	flag.StringVar(&c.InsertOrder, "insert_order", "random", "order of inserted ids; sequential to insert increasing ids on every write, random to insert or update random ids")
+	flag.StringVar(&c.ReadConsistency, "read_consistency", "autocommit", "consistency of reads; autocommit to read without transaction, repeatable_read to read in a repeatable read transaction")
+	flag.StringVar(&c.Databases, "databases", "", "comma separated schemas to create the table in and run operations on by key; empty to use -db only")
+	flag.BoolVar(&c.VerifyCleanup, "verify_cleanup", false, "verify the table is dropped after the test, and exit non-zero if not")
+	flag.IntVar(&c.HotKeys, "hot_keys", 0, "number of hot ids to concentrate writes on to study lock contention, reported for operations on them; 0 to write all ids")
+	flag.IntVar(&c.Churn, "churn", 0, "number of goroutines opening and closing connections repeatedly during the run, to measure the connection establishment rate")
+	flag.BoolVar(&c.Prewarm, "prewarm", false, "open -req_count idle connections of the pool before the run, so the run doesn't include connection establishment")
+	flag.IntVar(&c.PrewarmParallelism, "prewarm_parallelism", 8, "max number of connections opened concurrently during the prewarm; batches are opened with a short pause between them")
+	flag.BoolVar(&c.Decode, "decode", false, "write values as JSON documents, and unmarshal values read as JSON to measure the cost of reading and decoding")
+	flag.StringVar(&c.TxStatements, "tx_statements", "", "comma separated statements run in order in a transaction as a write, such as insert,update; insert upserts the row. empty to write by a single statement")
+	flag.BoolVar(&c.TxSameKey, "tx_same_key", true, "run all statements of -tx_statements on the id of the write; otherwise the i-th statement runs on the id plus i")
 }
 
-func (c config) check() error {
-	return validator.New().Struct(c)
+// check validates the config, and its combination with sConf.
+func (c config) check(sConf *stats.Config) error {
+	if err := validator.New().Struct(c); err != nil {
+		return err
+	}
+	if sConf.IntegritySweep && c.InsertOrder == "sequential" {
+		// rows aren't written by the ids of writes, so the sweep would report
+		// them missing.
+		return errors.New("-integrity_sweep requires -insert_order=random")
+	}
+	_, err := parseTxStatements(c.TxStatements)
+	return err
+}
+
+// txStatements are queries of statements of -tx_statements by name. args of
+// the queries are the value and the id.
+var txStatements = map[string]string{
+	"insert": "INSERT INTO %s(value, id) VALUES(?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value)",
+	"update": "UPDATE %s SET value = ? WHERE id = ?",
+}
+
+// parseTxStatements parses -tx_statements, and returns nil for an empty value.
+func parseTxStatements(v string) ([]string, error) {
+	if v == "" {
+		return nil, nil
+	}
+	statements := strings.Split(v, ",")
+	for _, stmt := range statements {
+		if _, ok := txStatements[stmt]; !ok {
+			return nil, fmt.Errorf("unknown statement %q in -tx_statements; must be insert or update", stmt)
+		}
+	}
+	return statements, nil
 }
 
 func main() {
-	conf, sts, err := initialize()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the test. errors are returned instead of exiting, so deferred
+// cleanup runs even if the test fails.
+func run() (err error) {
+	ctx := context.Background()
+	conf, sts, pConf, err := initialize()
 	if err != nil {
-		log.Fatalf(err.Error())
+		return err
 	}
 
-	db, err := sql.Open("mysql", fmt.Sprintf(
-		"%s:%s@unix(%s/%s)/%s",
-		conf.User, conf.Pass, conf.Socket, conf.Conn, conf.DB,
-	))
+	if sts.Config.Estimate {
+		p, err := sts.Config.Project(pConf.RowSize)
+		if err != nil {
+			return err
+		}
+		fmt.Print(p)
+		return nil
+	}
+	var (
+		codec = newValueCodec(payload.NewCodec(pConf), conf.Decode)
+		gen   = payload.NewGenerator(pConf)
+	)
+
+	db, err := sql.Open("mysql", dsn(conf))
 	defer db.Close()
 	db.SetMaxIdleConns(sts.Config.ReqCount)
+	if conf.Prewarm {
+		if err := prewarm(ctx, db, sts.Config.ReqCount, conf.PrewarmParallelism); err != nil {
+			return err
+		}
+	}
 
-	if err := createTable(db, conf.Table); err != nil {
-		log.Fatalf(err.Error())
+	schemas := newSchemas(conf)
+	for _, sc := range schemas {
+		if err := createTable(ctx, db, sc.table, conf.Charset); err != nil {
+			return err
+		}
 	}
-	defer dropTable(db, conf.Table)
+	defer func() {
+		if cerr := cleanup(ctx, db, conf, schemas); cerr != nil {
+			log.Printf("Error cleaning up: %v", cerr)
+			// the error of the test takes precedence.
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
 
 	var (
-		mapLock  sync.Mutex
-		inserted = make(map[int]bool)
-		readFunc = func(ctx context.Context, id int) error {
-			return find(ctx, db, conf.Table, id)
-		}
-		writeFunc = func(ctx context.Context, id int) error {
-			var err error
-			mapLock.Lock()
-			if inserted[id] {
-				mapLock.Unlock()
-				err = update(ctx, db, conf.Table, id)
-			} else {
-				inserted[id] = true
-				mapLock.Unlock()
-				err = insert(ctx, db, conf.Table, id)
-			}
+		contention          = newErrorCounter("contention")
+		readFunc, writeFunc = newOps(db, codec, newWriter(db, codec, gen, conf), schemas, conf, contention)
+	)
+	sts.Heartbeat = db.PingContext
+	sts.Fatal = fatalError
+	sts.Throttled = throttleError
+	sts.AppendHistory = history.Append
+	sts.Sweep = func(ctx context.Context, id int) error {
+		if conf.HotKeys > 0 {
+			id %= conf.HotKeys
+		}
+		return sweepRow(ctx, db, codec, schemaOf(schemas, id), id)
+	}
+
+	if sts.Config.MultiRun() {
+		runs, err := sts.StartRuns(readFunc, writeFunc)
+		if err != nil {
 			return err
 		}
-	)
+		log.Printf("Comparison:\n%v", stats.Compare(runs))
+		if v := stats.Verdict(runs); v != "" {
+			log.Printf("Verdict:\n%v", v)
+		}
+		if err := sts.WriteManifest(); err != nil {
+			log.Printf("Error writing manifest: %v", err)
+		}
+		return nil
+	}
+
+	var ch *churn
+	if conf.Churn > 0 {
+		ch = startChurn(mysql.MySQLDriver{}, dsn(conf), conf.Churn)
+	}
 	readRec, writeRec, err := sts.Start(readFunc, writeFunc)
+	if ch != nil {
+		ch.stop()
+	}
 	if err != nil {
-		log.Fatalf(err.Error())
+		return err
 	}
 
 	log.Printf("Reads (%d ok / %d tries):\n%v", readRec.Ok, readRec.Tries, readRec.Aggregate())
 	log.Printf("Writes (%d ok / %d tries):\n%v", writeRec.Ok, writeRec.Tries, writeRec.Aggregate())
+	if sts.GC != nil {
+		log.Printf("GC:\n%v", sts.GC)
+	}
+	if ch != nil {
+		log.Printf("Connections (%d established / %d tries, %.1f/s):\n%v", ch.conns.Ok, ch.conns.Tries, ch.rate(), ch.conns.Aggregate())
+	}
+	if conf.HotKeys > 0 {
+		log.Printf("Contention (of %d ops on hot keys):\n%v", contention.total(), contention.report())
+	}
+	if conf.Decode {
+		log.Printf("Decode failures: %d", codec.failures())
+	}
+	if codec.Enabled() {
+		log.Printf("Compress (%d ok / %d tries):\n%v", codec.Compress.Ok, codec.Compress.Tries, codec.Compress.Aggregate())
+		log.Printf("Decompress (%d ok / %d tries):\n%v", codec.Decompress.Ok, codec.Decompress.Tries, codec.Decompress.Aggregate())
+	}
+	if len(schemas) > 1 {
+		for _, sc := range schemas {
+			log.Printf("Reads on %s (%d ok / %d tries):\n%v", sc.name, sc.reads.Ok, sc.reads.Tries, sc.reads.Aggregate())
+			log.Printf("Writes on %s (%d ok / %d tries):\n%v", sc.name, sc.writes.Ok, sc.writes.Tries, sc.writes.Aggregate())
+		}
+	}
+	if err := sts.WriteManifest(); err != nil {
+		log.Printf("Error writing manifest: %v", err)
+	}
+	return nil
+}
+
+// newOps returns the read and the write of the test on schemas. under
+// -hot_keys, writes are concentrated on the hot keys, and errors of operations
+// on them are counted by contention.
+func newOps(db *sql.DB, codec *valueCodec, w *writer, schemas []*schema, conf *config, contention *errorCounter) (readFunc, writeFunc stats.StatsFunc) {
+	hot := func(id int) bool {
+		return id < conf.HotKeys
+	}
+	readFunc = func(ctx context.Context, id int) error {
+		err := schemaOf(schemas, id).find(ctx, db, codec, conf.ReadConsistency, id)
+		if hot(id) {
+			contention.count(err)
+		}
+		return err
+	}
+	writeFunc = func(ctx context.Context, id int) error {
+		if conf.HotKeys > 0 {
+			id %= conf.HotKeys
+		}
+		err := schemaOf(schemas, id).write(ctx, w, id)
+		if hot(id) {
+			contention.count(err)
+		}
+		return err
+	}
+	return readFunc, writeFunc
+}
+
+// prewarmPause is the pause between batches of connections opened by prewarm.
+const prewarmPause = 100 * time.Millisecond
+
+// prewarm opens n connections of the pool in batches of at most parallelism
+// connections, and returns them to the pool as idle connections. opening all
+// connections at once can overwhelm the proxy.
+func prewarm(ctx context.Context, db *sql.DB, n, parallelism int) error {
+	var (
+		start = time.Now()
+		conns []*sql.Conn
+	)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for len(conns) < n {
+		if len(conns) > 0 {
+			time.Sleep(prewarmPause)
+		}
+		batch := n - len(conns)
+		if batch > parallelism {
+			batch = parallelism
+		}
+		var (
+			wg     sync.WaitGroup
+			opened = make([]*sql.Conn, batch)
+			errs   = make([]error, batch)
+		)
+		for i := 0; i < batch; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				conn, err := db.Conn(ctx)
+				if err == nil {
+					err = conn.PingContext(ctx)
+				}
+				opened[i], errs[i] = conn, err
+			}(i)
+		}
+		wg.Wait()
+		// every connection of the batch is collected before returning an
+		// error, so that it's closed.
+		var err error
+		for i, conn := range opened {
+			if conn != nil {
+				conns = append(conns, conn)
+			}
+			if err == nil && errs[i] != nil {
+				err = fmt.Errorf("prewarm: %v", errs[i])
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("Prewarmed %d connections in %v", len(conns), time.Since(start))
+	return nil
+}
+
+// churn opens and closes connections repeatedly, bypassing the pool of
+// sql.DB, to record the latency of establishing a connection.
+type churn struct {
+	conns   stats.Recorder
+	started time.Time
+	elapsed time.Duration
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startChurn starts churning connections to dsn opened by d on n goroutines.
+func startChurn(d driver.Driver, dsn string, n int) *churn {
+	c := &churn{started: time.Now(), done: make(chan struct{})}
+	for i := 0; i < n; i++ {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			for {
+				select {
+				case <-c.done:
+					return
+				default:
+				}
+				start := time.Now()
+				conn, err := d.Open(dsn)
+				c.conns.Record(err == nil, time.Since(start))
+				if err != nil {
+					log.Printf("Error opening connection: %v", err)
+					continue
+				}
+				conn.Close()
+			}
+		}()
+	}
+	return c
+}
+
+func (c *churn) stop() {
+	close(c.done)
+	c.wg.Wait()
+	c.elapsed = time.Since(c.started)
+}
+
+// rate returns connections established per second.
+func (c *churn) rate() float64 {
+	if c.elapsed <= 0 {
+		return 0
+	}
+	return float64(c.conns.Ok) / c.elapsed.Seconds()
+}
+
+// schema is a database which the scratch table is created in.
+type schema struct {
+	name   string
+	table  string
+	reads  stats.Recorder
+	writes stats.Recorder
+}
+
+func newSchemas(conf *config) []*schema {
+	names := []string{conf.DB}
+	if conf.Databases != "" {
+		names = strings.Split(conf.Databases, ",")
+	}
+	var schemas []*schema
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		schemas = append(schemas, &schema{
+			name:  name,
+			table: fmt.Sprintf("%s.%s", name, conf.Table),
+		})
+	}
+	return schemas
+}
+
+// schemaOf returns the schema to run an operation of id on. keys are spread
+// over schemas, so a key is always read from the schema it's written to.
+func schemaOf(schemas []*schema, id int) *schema {
+	return schemas[id%len(schemas)]
+}
+
+// find finds a row of id in the schema with the read consistency, and records
+// the read latency of the schema.
+func (sc *schema) find(ctx context.Context, db *sql.DB, codec *valueCodec, consistency string, id int) error {
+	start := time.Now()
+	var err error
+	if consistency == "autocommit" {
+		err = find(ctx, db, codec, sc.table, id)
+	} else {
+		err = findInTx(ctx, db, sql.LevelRepeatableRead, codec, sc.table, id)
+	}
+	sc.reads.Record(err == nil, time.Since(start))
+	return err
+}
+
+// write writes a row of id in the schema by w, and records the write latency
+// of the schema.
+func (sc *schema) write(ctx context.Context, w *writer, id int) error {
+	start := time.Now()
+	err := w.write(ctx, sc.table, id)
+	sc.writes.Record(err == nil, time.Since(start))
+	return err
+}
+
+type rowKey struct {
+	table string
+	id    int
 }
 
-func initialize() (*config, *stats.Stats, error) {
+// writer inserts a row of id, or updates the row if it has been inserted.
+// on sequential insert order, it inserts a row of the next id instead. if
+// -tx_statements is set, it runs the statements in a transaction instead.
+type writer struct {
+	db           *sql.DB
+	codec        *valueCodec
+	gen          *payload.Generator
+	insertOrder  string
+	txStatements []string
+	txSameKey    bool
+	lastID       int64
+
+	mu       sync.Mutex
+	inserted map[rowKey]bool
+}
+
+func newWriter(db *sql.DB, codec *valueCodec, gen *payload.Generator, conf *config) *writer {
+	// -tx_statements is validated by config.check.
+	statements, _ := parseTxStatements(conf.TxStatements)
+	return &writer{
+		db:           db,
+		codec:        codec,
+		gen:          gen,
+		insertOrder:  conf.InsertOrder,
+		txStatements: statements,
+		txSameKey:    conf.TxSameKey,
+		inserted:     make(map[rowKey]bool),
+	}
+}
+
+func (w *writer) write(ctx context.Context, table string, id int) error {
+	if w.txStatements != nil {
+		return w.writeTx(ctx, table, id)
+	}
+	if w.insertOrder == "sequential" {
+		return insert(ctx, w.db, w.codec, w.gen, table, int(atomic.AddInt64(&w.lastID, 1)))
+	}
+	key := rowKey{table: table, id: id}
+	w.mu.Lock()
+	if w.inserted[key] {
+		w.mu.Unlock()
+		return update(ctx, w.db, w.codec, w.gen, table, id)
+	}
+	w.inserted[key] = true
+	w.mu.Unlock()
+	return insert(ctx, w.db, w.codec, w.gen, table, id)
+}
+
+// writeTx runs -tx_statements in order in a transaction. the i-th statement
+// runs on id, or id plus i unless -tx_same_key is set, so the order of locks
+// taken by concurrent transactions is reproduced.
+func (w *writer) writeTx(ctx context.Context, table string, id int) error {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for i, stmt := range w.txStatements {
+		key := id
+		if !w.txSameKey {
+			key += i
+		}
+		buf := w.gen.Get(key)
+		value, err := w.codec.encode(ctx, key, buf)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, fmt.Sprintf(txStatements[stmt], table), value, key)
+		}
+		w.gen.Put(buf)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func initialize() (*config, *stats.Stats, *payload.Config, error) {
 	var (
 		conf  = new(config)
 		sConf = stats.NewConfig()
+		pConf = payload.NewConfig()
 	)
+	sConf.Backend = "cloudsql"
 	conf.registerFlags()
 	sConf.RegisterFlags()
-	flag.Parse()
+	pConf.RegisterFlags()
+	if err := sConf.ParseFlags(); err != nil {
+		return nil, nil, nil, err
+	}
 
-	if err := conf.check(); err != nil {
-		return nil, nil, err
+	if err := conf.check(sConf); err != nil {
+		return nil, nil, nil, err
 	}
 	if err := sConf.Validate(); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	return conf, stats.NewStats(sConf), nil
+	if err := pConf.Validate(); err != nil {
+		return nil, nil, nil, err
+	}
+	return conf, stats.NewStats(sConf), pConf, nil
+}
+
+// mysqlError is a MySQL error number classified by how it affects the run.
+type mysqlError struct {
+	name string
+	// category is fatal for errors which won't be resolved by retrying,
+	// contention for errors caused by concurrent updates to the same rows, or
+	// throttle for errors of the server limiting clients.
+	category string
 }
 
-func createTable(db *sql.DB, table string) error {
-	_, err := db.Exec(fmt.Sprintf(
-		"CREATE TABLE %s(id int primary key, value blob)", table,
-	))
+var mysqlErrors = map[uint16]mysqlError{
+	1044: {name: "ER_DBACCESS_DENIED_ERROR", category: "fatal"},
+	1045: {name: "ER_ACCESS_DENIED_ERROR", category: "fatal"},
+	1040: {name: "ER_CON_COUNT_ERROR", category: "throttle"},
+	1049: {name: "ER_BAD_DB_ERROR", category: "fatal"},
+	1146: {name: "ER_NO_SUCH_TABLE", category: "fatal"},
+	1203: {name: "ER_TOO_MANY_USER_CONNECTIONS", category: "throttle"},
+	1205: {name: "ER_LOCK_WAIT_TIMEOUT", category: "contention"},
+	1213: {name: "ER_LOCK_DEADLOCK", category: "contention"},
+}
+
+// classify returns the number and the classification of err if it's a known
+// MySQL error.
+func classify(err error) (uint16, mysqlError, bool) {
+	merr, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return 0, mysqlError{}, false
+	}
+	class, ok := mysqlErrors[merr.Number]
+	return merr.Number, class, ok
+}
+
+func fatalError(err error) bool {
+	_, class, ok := classify(err)
+	return ok && class.category == "fatal"
+}
+
+func throttleError(err error) bool {
+	_, class, ok := classify(err)
+	return ok && class.category == "throttle"
+}
+
+// errorCounter counts MySQL errors of a category by number, out of the
+// operations counted.
+type errorCounter struct {
+	category string
+
+	mu     sync.Mutex
+	ops    int
+	counts map[uint16]int
+}
+
+func newErrorCounter(category string) *errorCounter {
+	return &errorCounter{category: category, counts: make(map[uint16]int)}
+}
+
+// count counts an operation, and its error if it's of the category.
+func (c *errorCounter) count(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ops++
+	if number, class, ok := classify(err); ok && class.category == c.category {
+		c.counts[number]++
+	}
+}
+
+// total returns the number of operations counted.
+func (c *errorCounter) total() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ops
+}
+
+// report returns counts of each error of the category with its rate in the
+// operations counted.
+func (c *errorCounter) report() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var numbers []int
+	for number := range mysqlErrors {
+		if mysqlErrors[number].category == c.category {
+			numbers = append(numbers, int(number))
+		}
+	}
+	sort.Ints(numbers)
+
+	var b strings.Builder
+	for _, number := range numbers {
+		var (
+			count = c.counts[uint16(number)]
+			rate  float64
+		)
+		if c.ops > 0 {
+			rate = float64(count) / float64(c.ops) * 100
+		}
+		fmt.Fprintf(&b, "%s: %d (%.2f%%)\n", mysqlErrors[uint16(number)].name, count, rate)
+	}
+	return b.String()
+}
+
+func dsn(conf *config) string {
+	dsn := fmt.Sprintf(
+		"%s:%s@unix(%s/%s)/%s",
+		conf.User, conf.Pass, conf.Socket, conf.Conn, conf.DB,
+	)
+	if conf.Charset != "" {
+		dsn += "?charset=" + conf.Charset
+	}
+	return dsn
+}
+
+func createTable(ctx context.Context, db *sql.DB, table, charset string) error {
+	_, err := db.ExecContext(ctx, createTableQuery(table, charset))
+	return err
+}
+
+func createTableQuery(table, charset string) string {
+	query := fmt.Sprintf("CREATE TABLE %s(id int primary key, value blob)", table)
+	if charset != "" {
+		query += " DEFAULT CHARSET=" + charset
+	}
+	return query
+}
+
+func dropTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s", table))
 	return err
 }
 
-func dropTable(db *sql.DB, table string) error {
-	_, err := db.Exec(fmt.Sprintf("DROP TABLE %s", table))
+// cleanup drops the tables, and verifies they are dropped if -verify_cleanup
+// is set.
+func cleanup(ctx context.Context, db *sql.DB, conf *config, schemas []*schema) error {
+	var err error
+	for _, sc := range schemas {
+		if derr := dropTable(ctx, db, sc.table); derr != nil {
+			log.Printf("Error dropping table %s: %v", sc.table, derr)
+			err = derr
+		}
+	}
+	if !conf.VerifyCleanup {
+		return err
+	}
+	for _, sc := range schemas {
+		var count int
+		if verr := db.QueryRowContext(
+			ctx,
+			"SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = ? AND table_name = ?",
+			sc.name, conf.Table,
+		).Scan(&count); verr != nil {
+			return fmt.Errorf("verify cleanup: %v", verr)
+		}
+		if count > 0 {
+			return fmt.Errorf("verify cleanup: table %s is left behind", sc.table)
+		}
+	}
 	return err
 }
 
-func insert(ctx context.Context, db *sql.DB, tableName string, id int) error {
+func insert(ctx context.Context, db *sql.DB, codec *valueCodec, gen *payload.Generator, tableName string, id int) error {
 	// insert iKB row.
-	_, err := db.ExecContext(
+	buf := gen.Get(id)
+	defer gen.Put(buf)
+	value, err := codec.encode(ctx, id, buf)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(
 		ctx,
 		fmt.Sprintf("INSERT INTO %s VALUES(?, ?)", tableName),
-		id, bytes.Repeat([]byte("0"), 1<<10),
+		id, value,
 	)
 	return err
 }
 
-func update(ctx context.Context, db *sql.DB, tableName string, id int) error {
+func update(ctx context.Context, db *sql.DB, codec *valueCodec, gen *payload.Generator, tableName string, id int) error {
 	// update iKB row.
-	_, err := db.ExecContext(
+	buf := gen.Get(id)
+	defer gen.Put(buf)
+	value, err := codec.encode(ctx, id, buf)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(
 		ctx,
 		fmt.Sprintf("UPDATE %s SET value=? WHERE id=?", tableName),
-		bytes.Repeat([]byte("0"), 1<<10), id,
+		value, id,
 	)
 	return err
 }
 
-func find(ctx context.Context, db *sql.DB, tableName string, id int) error {
+// document is a value written and read as JSON by -decode.
+type document struct {
+	ID      int    `json:"id"`
+	Payload []byte `json:"payload"`
+}
+
+// valueCodec encodes and decodes values of rows by the payload codec, and
+// by JSON as well under -decode.
+type valueCodec struct {
+	*payload.Codec
+	asJSON         bool
+	decodeFailures int64
+}
+
+func newValueCodec(codec *payload.Codec, asJSON bool) *valueCodec {
+	return &valueCodec{Codec: codec, asJSON: asJSON}
+}
+
+// encode returns the value of the row of id with the payload.
+func (c *valueCodec) encode(ctx context.Context, id int, buf []byte) ([]byte, error) {
+	if c.asJSON {
+		b, err := json.Marshal(document{ID: id, Payload: buf})
+		if err != nil {
+			return nil, err
+		}
+		buf = b
+	}
+	return c.Encode(ctx, buf)
+}
+
+// decode decodes the value of a row. failures of unmarshalling JSON are
+// counted apart from errors of the payload codec.
+func (c *valueCodec) decode(ctx context.Context, value []byte) error {
+	b, err := c.Decode(ctx, value)
+	if err != nil || !c.asJSON {
+		return err
+	}
+	var doc document
+	if err := json.Unmarshal(b, &doc); err != nil {
+		atomic.AddInt64(&c.decodeFailures, 1)
+		return fmt.Errorf("decode: %v", err)
+	}
+	return nil
+}
+
+func (c *valueCodec) failures() int64 {
+	return atomic.LoadInt64(&c.decodeFailures)
+}
+
+// sweepRow reads the row of id written to the table of sc, and returns
+// stats.ErrMissing if the table doesn't have it.
+func sweepRow(ctx context.Context, db *sql.DB, codec *valueCodec, sc *schema, id int) error {
+	var value []byte
+	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT value FROM %s WHERE id = ?", sc.table), id).Scan(&value)
+	if err == sql.ErrNoRows {
+		return stats.ErrMissing
+	} else if err != nil {
+		return err
+	}
+	return codec.decode(ctx, value)
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// txBeginner is implemented by *sql.DB.
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// findInTx finds a row in a read only transaction with the isolation level.
+func findInTx(ctx context.Context, db txBeginner, level sql.IsolationLevel, codec *valueCodec, tableName string, id int) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: level, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	if err := find(ctx, tx, codec, tableName, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func find(ctx context.Context, db queryer, codec *valueCodec, tableName string, id int) error {
 	// select row
 	rows, err := db.QueryContext(
 		ctx,
@@ -155,6 +822,9 @@ func find(ctx context.Context, db *sql.DB, tableName string, id int) error {
 		if err := rows.Scan(&id, &value); err != nil {
 			return err
 		}
+		if err := codec.decode(ctx, value); err != nil {
+			return err
+		}
 	}
 	return nil
 }
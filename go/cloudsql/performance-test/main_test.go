@@ -0,0 +1,597 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/ryutah/gcp-sample/go/internal/payload"
+	"github.com/ryutah/gcp-sample/go/internal/stats"
+)
+
+// newTestConfig returns a valid config.
+func newTestConfig() *config {
+	return &config{
+		Table:  "scratch",
+		DB:     "db",
+		Conn:   "project:region:instance",
+		User:   "user",
+		Pass:   "pass",
+		Socket: "/cloudsql",
+
+		InsertOrder:     "random",
+		ReadConsistency: "autocommit",
+
+		PrewarmParallelism: 8,
+	}
+}
+
+func TestCharset(t *testing.T) {
+	conf := newTestConfig()
+	if got := dsn(conf); strings.Contains(got, "charset") {
+		t.Errorf("dsn() = %q, want no charset by default", got)
+	}
+	if got := createTableQuery(conf.Table, conf.Charset); strings.Contains(got, "CHARSET") {
+		t.Errorf("createTableQuery() = %q, want no charset by default", got)
+	}
+
+	conf.Charset = "utf8mb4"
+	if err := conf.check(stats.NewConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dsn(conf), "user:pass@unix(/cloudsql/project:region:instance)/db?charset=utf8mb4"; got != want {
+		t.Errorf("dsn() = %q, want %q", got, want)
+	}
+	if got := createTableQuery(conf.Table, conf.Charset); !strings.HasSuffix(got, " DEFAULT CHARSET=utf8mb4") {
+		t.Errorf("createTableQuery() = %q, want the charset clause", got)
+	}
+
+	conf.Charset = "utf16"
+	if err := conf.check(stats.NewConfig()); err == nil {
+		t.Errorf("check() of charset %s = nil, want error", conf.Charset)
+	}
+}
+
+// idArg matches any id, and records it to ids.
+type idArg struct {
+	ids *[]int64
+}
+
+func (a idArg) Match(v driver.Value) bool {
+	id, ok := v.(int64)
+	*a.ids = append(*a.ids, id)
+	return ok
+}
+
+func TestSequentialInsertOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var (
+		ids  []int64
+		conf = newTestConfig()
+	)
+	conf.InsertOrder = "sequential"
+	pConf := payload.NewConfig()
+	w := newWriter(db, newValueCodec(payload.NewCodec(pConf), false), payload.NewGenerator(pConf), conf)
+	// ids of operations repeat, but every write inserts a new row.
+	for _, id := range []int{5, 5, 3, 9, 1, 5} {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO scratch")).
+			WithArgs(idArg{ids: &ids}, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := w.write(context.Background(), conf.Table, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("inserted ids = %v, want strictly increasing", ids)
+		}
+	}
+}
+
+// recordTxOptions records options of transactions begun on db.
+type recordTxOptions struct {
+	db   *sql.DB
+	opts []*sql.TxOptions
+}
+
+func (r *recordTxOptions) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	r.opts = append(r.opts, opts)
+	return r.db.BeginTx(ctx, opts)
+}
+
+func TestFindInTx(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM scratch WHERE id = ?")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "value"}).AddRow(1, []byte("value")))
+	mock.ExpectCommit()
+
+	rec := &recordTxOptions{db: db}
+	codec := newValueCodec(payload.NewCodec(payload.NewConfig()), false)
+	if err := findInTx(context.Background(), rec, sql.LevelRepeatableRead, codec, "scratch", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if len(rec.opts) != 1 || rec.opts[0].Isolation != sql.LevelRepeatableRead || !rec.opts[0].ReadOnly {
+		t.Errorf("transaction options = %+v, want a read only repeatable read transaction", rec.opts)
+	}
+}
+
+func TestDatabases(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	conf := newTestConfig()
+	conf.Databases = "db1, db2"
+	schemas := newSchemas(conf)
+	if len(schemas) != 2 || schemas[0].table != "db1.scratch" || schemas[1].table != "db2.scratch" {
+		t.Fatalf("schemas = %+v, want tables in db1 and db2", schemas)
+	}
+
+	const ops = 10
+	for id := 0; id < ops; id++ {
+		// keys are spread over db1 and db2 by id. reads on db2 fail, so its
+		// stats are told apart from db1.
+		if id%2 == 0 {
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO db1.scratch")).
+				WithArgs(id, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM db1.scratch WHERE id = ?")).
+				WithArgs(id).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+		} else {
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO db2.scratch")).
+				WithArgs(id, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM db2.scratch WHERE id = ?")).
+				WithArgs(id).
+				WillReturnError(errors.New("db2 is down"))
+		}
+	}
+	var (
+		pConf = payload.NewConfig()
+		codec = newValueCodec(payload.NewCodec(pConf), false)
+		w     = newWriter(db, codec, payload.NewGenerator(pConf), conf)
+	)
+	for id := 0; id < ops; id++ {
+		sc := schemaOf(schemas, id)
+		sc.write(context.Background(), w, id)
+		sc.find(context.Background(), db, codec, conf.ReadConsistency, id)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if r := &schemas[0].reads; r.Tries != ops/2 || r.Ok != ops/2 {
+		t.Errorf("reads on db1 = %d ok / %d tries, want %d / %d", r.Ok, r.Tries, ops/2, ops/2)
+	}
+	if r := &schemas[1].reads; r.Tries != ops/2 || r.Ok != 0 {
+		t.Errorf("reads on db2 = %d ok / %d tries, want 0 / %d", r.Ok, r.Tries, ops/2)
+	}
+	for _, sc := range schemas {
+		if sc.writes.Tries != ops/2 || sc.writes.Ok != ops/2 {
+			t.Errorf("writes on %s = %d ok / %d tries, want %d / %d", sc.name, sc.writes.Ok, sc.writes.Tries, ops/2, ops/2)
+		}
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	conf := newTestConfig()
+	conf.VerifyCleanup = true
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE db.scratch")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM information_schema.tables")).
+		WithArgs("db", "scratch").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	if err := cleanup(context.Background(), db, conf, newSchemas(conf)); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCleanupCancelled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the drop hangs, and returns by the cancelled context of the run.
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE db.scratch")).
+		WillDelayFor(time.Minute).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	conf := newTestConfig()
+	start := time.Now()
+	if err := cleanup(ctx, db, conf, newSchemas(conf)); err == nil {
+		t.Error("cleanup() succeeded with a cancelled context")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("cleanup() took %v with a cancelled context", d)
+	}
+}
+
+func TestFatalError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: &mysql.MySQLError{Number: 1045, Message: "Access denied"}, want: true},
+		{err: &mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"}, want: true},
+		{err: &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, want: false},
+		{err: errors.New("connection reset"), want: false},
+	}
+	for _, tt := range tests {
+		if got := fatalError(tt.err); got != tt.want {
+			t.Errorf("fatalError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestContention(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	lockWait := &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO scratch")).WillReturnError(lockWait)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO scratch")).WillReturnError(lockWait)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO scratch")).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var (
+		conf       = newTestConfig()
+		pConf      = payload.NewConfig()
+		w          = newWriter(db, newValueCodec(payload.NewCodec(pConf), false), payload.NewGenerator(pConf), conf)
+		contention = newErrorCounter("contention")
+	)
+	for id := 1; id <= 3; id++ {
+		contention.count(w.write(context.Background(), conf.Table, id))
+	}
+	// errors of other categories aren't counted.
+	contention.count(&mysql.MySQLError{Number: 1045, Message: "Access denied"})
+	contention.count(errors.New("connection reset"))
+	contention.count(&mysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	report := contention.report()
+	for _, line := range []string{"ER_LOCK_WAIT_TIMEOUT: 2 (33.33%)", "ER_LOCK_DEADLOCK: 1 (16.67%)"} {
+		if !strings.Contains(report, line) {
+			t.Errorf("contention report doesn't have %q:\n%s", line, report)
+		}
+	}
+	if strings.Contains(report, "ER_ACCESS_DENIED_ERROR") {
+		t.Errorf("contention report has a fatal error:\n%s", report)
+	}
+}
+
+func TestContentionOnHotKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	lockWait := &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+	// writes of ids 7 and 8 are concentrated on the hot ids 1 and 0.
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO db.scratch")).WithArgs(1, sqlmock.AnyArg()).WillReturnError(lockWait)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO db.scratch")).WithArgs(0, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM db.scratch WHERE id = ?")).WithArgs(1).WillReturnError(lockWait)
+	// the read of id 5 isn't on a hot key, so its error isn't counted.
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM db.scratch WHERE id = ?")).WithArgs(5).WillReturnError(lockWait)
+
+	var (
+		conf       = newTestConfig()
+		pConf      = payload.NewConfig()
+		codec      = newValueCodec(payload.NewCodec(pConf), false)
+		contention = newErrorCounter("contention")
+	)
+	conf.HotKeys = 2
+	readFunc, writeFunc := newOps(db, codec, newWriter(db, codec, payload.NewGenerator(pConf), conf), newSchemas(conf), conf, contention)
+	ctx := context.Background()
+	writeFunc(ctx, 7)
+	writeFunc(ctx, 8)
+	readFunc(ctx, 1)
+	readFunc(ctx, 5)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if n := contention.total(); n != 3 {
+		t.Errorf("ops on hot keys = %d, want 3", n)
+	}
+	if report, line := contention.report(), "ER_LOCK_WAIT_TIMEOUT: 2 (66.67%)"; !strings.Contains(report, line) {
+		t.Errorf("contention report doesn't have %q:\n%s", line, report)
+	}
+}
+
+// countingDriver opens connections which count their closes, failing every
+// failEvery-th open if it's set.
+type countingDriver struct {
+	failEvery     int64
+	opens, closes int64
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	n := atomic.AddInt64(&d.opens, 1)
+	if d.failEvery > 0 && n%d.failEvery == 0 {
+		return nil, errors.New("connection refused")
+	}
+	return &countingConn{closes: &d.closes}, nil
+}
+
+type countingConn struct {
+	driver.Conn
+	closes *int64
+}
+
+func (c *countingConn) Close() error {
+	atomic.AddInt64(c.closes, 1)
+	return nil
+}
+
+func TestChurn(t *testing.T) {
+	// failed opens are logged each.
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	d := &countingDriver{failEvery: 5}
+	ch := startChurn(d, "dsn", 3)
+	time.Sleep(20 * time.Millisecond)
+	ch.stop()
+
+	opens, closes := atomic.LoadInt64(&d.opens), atomic.LoadInt64(&d.closes)
+	if opens == 0 {
+		t.Fatal("no connection is opened")
+	}
+	if int64(ch.conns.Tries) != opens {
+		t.Errorf("recorded %d tries, want all of %d opens", ch.conns.Tries, opens)
+	}
+	if int64(ch.conns.Ok) != closes {
+		t.Errorf("recorded %d established, want %d reopened connections", ch.conns.Ok, closes)
+	}
+	if want := opens - opens/5; closes != want {
+		t.Errorf("closed %d connections, want %d of %d opens without the failed ones", closes, want, opens)
+	}
+	if ch.rate() <= 0 {
+		t.Errorf("rate() = %v, want positive", ch.rate())
+	}
+}
+
+// slowConnector opens connections slowly, tracking the max number of them
+// being opened concurrently. the failAt-th connection fails if it's set.
+type slowConnector struct {
+	mu              sync.Mutex
+	opening, opened int
+	maxOpening      int
+	failAt          int
+}
+
+func (c *slowConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	c.opening++
+	if c.opening > c.maxOpening {
+		c.maxOpening = c.opening
+	}
+	c.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	c.mu.Lock()
+	c.opening--
+	c.opened++
+	fail := c.opened == c.failAt
+	c.mu.Unlock()
+	if fail {
+		return nil, errors.New("connection refused")
+	}
+	return &countingConn{closes: new(int64)}, nil
+}
+
+func (c *slowConnector) Driver() driver.Driver { return nil }
+
+func TestPrewarm(t *testing.T) {
+	c := new(slowConnector)
+	db := sql.OpenDB(c)
+	defer db.Close()
+	db.SetMaxIdleConns(10)
+
+	if err := prewarm(context.Background(), db, 10, 3); err != nil {
+		t.Fatal(err)
+	}
+	if c.opened != 10 {
+		t.Errorf("opened %d connections, want 10", c.opened)
+	}
+	if c.maxOpening > 3 {
+		t.Errorf("opened %d connections concurrently, want at most 3", c.maxOpening)
+	}
+	if idle := db.Stats().Idle; idle != 10 {
+		t.Errorf("%d idle connections after the prewarm, want 10", idle)
+	}
+}
+
+func TestPrewarmError(t *testing.T) {
+	// each connection of the first batch fails in turn, so that the failed
+	// one precedes the others of the batch at least once.
+	for failAt := 1; failAt <= 3; failAt++ {
+		c := &slowConnector{failAt: failAt}
+		db := sql.OpenDB(c)
+		db.SetMaxIdleConns(10)
+		if err := prewarm(context.Background(), db, 10, 3); err == nil {
+			t.Error("prewarm() succeeded, want the error of the connection")
+		}
+		if st := db.Stats(); st.InUse != 0 || st.Idle != 2 {
+			t.Errorf("failing connection %d: %d connections in use and %d idle after the prewarm, want 0 and 2", failAt, st.InUse, st.Idle)
+		}
+		db.Close()
+	}
+}
+
+func TestDecode(t *testing.T) {
+	pConf := payload.NewConfig()
+	pConf.Compress = "gzip"
+	codec := newValueCodec(payload.NewCodec(pConf), true)
+	valid, err := codec.encode(context.Background(), 1, []byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid, err := codec.Encode(context.Background(), []byte("{not json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		value        []byte
+		wantErr      bool
+		wantFailures int64
+	}{
+		{name: "valid", value: valid},
+		{name: "invalid json", value: invalid, wantErr: true, wantFailures: 1},
+		// a failure of the payload codec isn't a decode failure.
+		{name: "not compressed", value: []byte(`{"id": 1}`), wantErr: true, wantFailures: 1},
+		{name: "invalid json again", value: invalid, wantErr: true, wantFailures: 2},
+	}
+	for _, tt := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM scratch WHERE id = ?")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "value"}).AddRow(1, tt.value))
+		err = find(context.Background(), db, codec, "scratch", 1)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: find() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if got := codec.failures(); got != tt.wantFailures {
+			t.Errorf("%s: %d decode failures, want %d", tt.name, got, tt.wantFailures)
+		}
+		db.Close()
+	}
+}
+
+func TestTxStatements(t *testing.T) {
+	tests := []struct {
+		name     string
+		stmts    string
+		sameKey  bool
+		wantIDs  []int
+		wantStmt []string
+	}{
+		{name: "update then insert", stmts: "update,insert", sameKey: true, wantIDs: []int{7, 7}, wantStmt: []string{"UPDATE scratch", "INSERT INTO scratch"}},
+		{name: "different keys", stmts: "insert,update,update", wantIDs: []int{7, 8, 9}, wantStmt: []string{"INSERT INTO scratch", "UPDATE scratch", "UPDATE scratch"}},
+	}
+	for _, tt := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf := newTestConfig()
+		conf.TxStatements = tt.stmts
+		conf.TxSameKey = tt.sameKey
+		if err := conf.check(stats.NewConfig()); err != nil {
+			t.Fatal(err)
+		}
+		pConf := payload.NewConfig()
+		w := newWriter(db, newValueCodec(payload.NewCodec(pConf), false), payload.NewGenerator(pConf), conf)
+
+		mock.ExpectBegin()
+		for i, stmt := range tt.wantStmt {
+			mock.ExpectExec(regexp.QuoteMeta(stmt)).
+				WithArgs(sqlmock.AnyArg(), tt.wantIDs[i]).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectCommit()
+		if err := w.write(context.Background(), conf.Table, 7); err != nil {
+			t.Errorf("%s: write() = %v", tt.name, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		db.Close()
+	}
+}
+
+func TestTxStatementsRollback(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conf := newTestConfig()
+	conf.TxStatements = "update,insert"
+	pConf := payload.NewConfig()
+	w := newWriter(db, newValueCodec(payload.NewCodec(pConf), false), payload.NewGenerator(pConf), conf)
+
+	// the transaction is rolled back on the first failed statement.
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE scratch")).WillReturnError(&mysql.MySQLError{Number: 1213})
+	mock.ExpectRollback()
+	if err := w.write(context.Background(), conf.Table, 7); err == nil {
+		t.Error("write() = nil, want the error of the statement")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckTxStatements(t *testing.T) {
+	conf := newTestConfig()
+	conf.TxStatements = "insert,delete"
+	if err := conf.check(stats.NewConfig()); err == nil {
+		t.Error("check() = nil, want an error for the unknown statement")
+	}
+}
+
+func TestCheckIntegritySweep(t *testing.T) {
+	sConf := stats.NewConfig()
+	sConf.IntegritySweep = true
+	conf := newTestConfig()
+	if err := conf.check(sConf); err != nil {
+		t.Errorf("check() with random insert order = %v", err)
+	}
+	conf.InsertOrder = "sequential"
+	if err := conf.check(sConf); err == nil {
+		t.Error("check() with sequential insert order = nil, want an error")
+	}
+}
@@ -0,0 +1,64 @@
+// Package history appends runs to a SQLite database given by -history_db. it's
+// apart from the stats package, so commands not setting
+// stats.Stats.AppendHistory don't link the database driver, which requires
+// cgo.
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ryutah/gcp-sample/go/internal/stats"
+)
+
+// driverName is the name of the driver registered by go-sqlite3 in builds
+// with cgo.
+const driverName = "sqlite3"
+
+const createTable = `CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	time TEXT NOT NULL,
+	flags TEXT NOT NULL,
+	passed INTEGER NOT NULL,
+	total_ops INTEGER NOT NULL,
+	error_rate REAL NOT NULL,
+	read_p99 REAL NOT NULL,
+	write_p99 REAL NOT NULL
+)`
+
+// Append appends run as a row of runs table in the database at path,
+// creating the table if absent. it's set to stats.Stats.AppendHistory.
+func Append(path string, run *stats.HistoryRun) error {
+	if !registered() {
+		return errors.New("-history_db requires a build with cgo")
+	}
+	db, err := sql.Open(driverName, path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(createTable); err != nil {
+		return err
+	}
+	flags, err := json.Marshal(run.Flags)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		"INSERT INTO runs(time, flags, passed, total_ops, error_rate, read_p99, write_p99) VALUES(?, ?, ?, ?, ?, ?, ?)",
+		run.Started.Format(time.RFC3339), string(flags), run.Passed, run.TotalOps, run.ErrorRate, run.ReadP99, run.WriteP99,
+	)
+	return err
+}
+
+func registered() bool {
+	for _, name := range sql.Drivers() {
+		if name == driverName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package history
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ryutah/gcp-sample/go/internal/stats"
+)
+
+func TestAppend(t *testing.T) {
+	if !registered() {
+		t.Skip("sqlite3 requires cgo")
+	}
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.db")
+
+	started := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, passed := range []bool{true, false} {
+		if err := Append(path, &stats.HistoryRun{
+			Started:   started.Add(time.Duration(i) * time.Hour),
+			Flags:     map[string]string{"run_for": "5s"},
+			Passed:    passed,
+			TotalOps:  100 + i,
+			ErrorRate: 0.5,
+			ReadP99:   0.01,
+			WriteP99:  0.02,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open(driverName, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT time, flags, passed, total_ops, error_rate, read_p99, write_p99 FROM runs ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var n int
+	for ; rows.Next(); n++ {
+		var (
+			tm, flags                    string
+			passed                       bool
+			totalOps                     int
+			errorRate, readP99, writeP99 float64
+		)
+		if err := rows.Scan(&tm, &flags, &passed, &totalOps, &errorRate, &readP99, &writeP99); err != nil {
+			t.Fatal(err)
+		}
+		wantTime := started.Add(time.Duration(n) * time.Hour).Format(time.RFC3339)
+		if tm != wantTime || flags != `{"run_for":"5s"}` || passed != (n == 0) || totalOps != 100+n ||
+			errorRate != 0.5 || readP99 != 0.01 || writeP99 != 0.02 {
+			t.Errorf("row %d = %v %v %v %v %v %v %v", n, tm, flags, passed, totalOps, errorRate, readP99, writeP99)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("%d rows, want 2", n)
+	}
+}
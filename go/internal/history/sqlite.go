@@ -0,0 +1,9 @@
+//go:build cgo
+// +build cgo
+
+package history
+
+import (
+	// registers the sqlite3 driver, which requires cgo.
+	_ "github.com/mattn/go-sqlite3"
+)
@@ -0,0 +1,81 @@
+package payload
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+)
+
+// Generator generates write payloads. if the buffer pool is enabled, payload
+// buffers are reused across writes to reduce allocations.
+type Generator struct {
+	pool    chan []byte
+	size    int
+	salt    string
+	pattern pattern
+}
+
+func NewGenerator(conf *Config) *Generator {
+	g := &Generator{size: conf.RowSize, salt: conf.PayloadSalt, pattern: patterns[conf.Pattern]}
+	if g.salt != "" && conf.Pattern == "fixed" {
+		// salted payloads are random unless a pattern is selected.
+		g.pattern = patterns["random"]
+	}
+	if conf.BufferPoolSize > 0 {
+		g.pool = make(chan []byte, conf.BufferPoolSize)
+		for i := 0; i < conf.BufferPoolSize; i++ {
+			g.pool <- g.newBuffer()
+		}
+	}
+	return g
+}
+
+func (g *Generator) newBuffer() []byte {
+	b := make([]byte, g.size)
+	if g.pattern.static {
+		g.pattern.fill(b, nil)
+	}
+	return b
+}
+
+// Get returns a payload for id filled by the pattern. the payload should be
+// returned by Put after it is written. a new payload is allocated if the pool
+// is disabled or exhausted. if the salt is set, the payload is derived from id
+// and the salt, so the same id has the same payload across runs with the same
+// salt.
+func (g *Generator) Get(id int) []byte {
+	var b []byte
+	select {
+	case b = <-g.pool:
+	default:
+		b = g.newBuffer()
+	}
+	switch {
+	case g.pattern.static:
+	case g.salt != "":
+		g.pattern.fill(b, saltedSource(g.salt, id))
+	default:
+		g.pattern.fill(b, globalSource{})
+	}
+	return b
+}
+
+// saltedSource returns a random source seeded by the salt and id.
+func saltedSource(salt string, id int) source {
+	h := fnv.New64a()
+	h.Write([]byte(salt))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.Itoa(id)))
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// Put returns a payload got by Get to the pool.
+func (g *Generator) Put(b []byte) {
+	if g.pool == nil {
+		return
+	}
+	select {
+	case g.pool <- b:
+	default:
+	}
+}
@@ -0,0 +1,83 @@
+package payload
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestGeneratorAllocs(t *testing.T) {
+	allocs := func(poolSize int) float64 {
+		conf := NewConfig()
+		conf.BufferPoolSize = poolSize
+		g := NewGenerator(conf)
+		return testing.AllocsPerRun(100, func() {
+			g.Put(g.Get(0))
+		})
+	}
+	without, with := allocs(0), allocs(4)
+	if with >= without {
+		t.Errorf("%v allocs/op with the pool, want less than %v without it", with, without)
+	}
+}
+
+func TestGeneratorConcurrent(t *testing.T) {
+	conf := NewConfig()
+	conf.BufferPoolSize = 2
+	var (
+		g  = NewGenerator(conf)
+		wg sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b := g.Get(j)
+				if len(b) != conf.RowSize {
+					t.Errorf("payload of %d bytes, want %d", len(b), conf.RowSize)
+				}
+				g.Put(b)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestGeneratorSalt(t *testing.T) {
+	get := func(salt string, id int) []byte {
+		conf := NewConfig()
+		conf.PayloadSalt = salt
+		return NewGenerator(conf).Get(id)
+	}
+	if !bytes.Equal(get("a", 1), get("a", 1)) {
+		t.Error("payloads of the same salt and id differ")
+	}
+	if bytes.Equal(get("a", 1), get("a", 2)) {
+		t.Error("payloads of different ids are the same")
+	}
+	if bytes.Equal(get("a", 1), get("b", 1)) {
+		t.Error("payloads of different salts are the same")
+	}
+}
+
+func BenchmarkGenerator(b *testing.B) {
+	benchmarks := []struct {
+		name     string
+		poolSize int
+	}{
+		{name: "no pool", poolSize: 0},
+		{name: "pool", poolSize: 16},
+	}
+	for _, bm := range benchmarks {
+		conf := NewConfig()
+		conf.BufferPoolSize = bm.poolSize
+		g := NewGenerator(conf)
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				g.Put(g.Get(i))
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package payload
+
+import (
+	"math/rand"
+)
+
+// patternNames are the names of patterns selectable by -payload_pattern.
+var patternNames = []string{"fixed", "zeros", "random", "text", "repeating"}
+
+// source is the random source patterns are filled from. it is a *rand.Rand
+// seeded by -payload_salt, or the shared source of math/rand otherwise.
+type source interface {
+	Intn(n int) int
+	Read(p []byte) (int, error)
+}
+
+// globalSource draws from the shared source of math/rand, which is safe for
+// concurrent use unlike a *rand.Rand.
+type globalSource struct{}
+
+func (globalSource) Intn(n int) int             { return rand.Intn(n) }
+func (globalSource) Read(p []byte) (int, error) { return rand.Read(p) }
+
+// pattern fills payloads with bytes modeling a kind of data, which backends
+// and codecs compress differently.
+type pattern struct {
+	fill func(b []byte, src source)
+	// static patterns don't draw from the source, so buffers are filled once
+	// when they are allocated.
+	static bool
+}
+
+var patterns = map[string]pattern{
+	// fixed is a run of ASCII '0's, the payload written before patterns.
+	"fixed":     {fill: fillByte('0'), static: true},
+	"zeros":     {fill: fillByte(0), static: true},
+	"random":    {fill: fillRandom},
+	"text":      {fill: fillText},
+	"repeating": {fill: fillRepeating},
+}
+
+func fillByte(c byte) func([]byte, source) {
+	return func(b []byte, _ source) {
+		for i := range b {
+			b[i] = c
+		}
+	}
+}
+
+// fillRandom fills b with incompressible bytes.
+func fillRandom(b []byte, src source) {
+	src.Read(b)
+}
+
+// textWords are the vocabulary of the text pattern. the frequency of a word
+// is skewed to its position to resemble natural language.
+var textWords = []string{
+	"the", "of", "and", "to", "a", "in", "is", "that", "for", "it",
+	"as", "was", "with", "be", "by", "on", "not", "he", "this", "are",
+	"or", "his", "from", "at", "which", "but", "have", "an", "had", "they",
+	"you", "were", "their", "one", "all", "we", "can", "her", "has", "there",
+	"been", "if", "more", "when", "will", "would", "who", "so", "no", "time",
+	"request", "latency", "storage", "cluster", "region", "instance", "table", "bucket", "object", "payload",
+}
+
+// fillText fills b with space separated words, compressible like text.
+func fillText(b []byte, src source) {
+	n := 0
+	for n < len(b) {
+		// the smaller of two draws favors words at the head of the vocabulary.
+		i, j := src.Intn(len(textWords)), src.Intn(len(textWords))
+		if j < i {
+			i = j
+		}
+		n += copy(b[n:], textWords[i])
+		if n < len(b) {
+			b[n] = ' '
+			n++
+		}
+	}
+}
+
+// repeatingBlockSize is the size of the block repeated by the repeating
+// pattern; it is far within the window of deflate.
+const repeatingBlockSize = 64
+
+// fillRepeating fills b with a random block repeated, which is random within
+// the block but highly compressible across blocks.
+func fillRepeating(b []byte, src source) {
+	block := b
+	if len(block) > repeatingBlockSize {
+		block = block[:repeatingBlockSize]
+	}
+	src.Read(block)
+	for n := len(block); n < len(b); n *= 2 {
+		copy(b[n:], b[:n])
+	}
+}
@@ -0,0 +1,143 @@
+package payload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"flag"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ryutah/gcp-sample/go/internal/stats"
+
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+type Config struct {
+	Compress       string `validate:"oneof=none gzip zlib"`
+	BufferPoolSize int    `validate:"min=0"`
+	PayloadSalt    string
+	Pattern        string `validate:"oneof=fixed zeros random text repeating"`
+	RowSize        int    `validate:"min=1"`
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Compress: "none",
+		Pattern:  "fixed",
+		RowSize:  1 << 10,
+	}
+}
+
+func (c *Config) RegisterFlags() {
+	flag.StringVar(
+		&c.Compress,
+		"compress",
+		c.Compress,
+		"codec to compress write payloads with; one of none, gzip, zlib",
+	)
+	flag.IntVar(
+		&c.BufferPoolSize,
+		"buffer_pool_size",
+		c.BufferPoolSize,
+		"number of payload buffers reused across writes; 0 to allocate a payload on every write",
+	)
+	flag.StringVar(
+		&c.PayloadSalt,
+		"payload_salt",
+		c.PayloadSalt,
+		"salt to derive write payloads from ids, so runs with the same salt write the same payload for an id; empty to write fixed payloads",
+	)
+	flag.StringVar(
+		&c.Pattern,
+		"payload_pattern",
+		c.Pattern,
+		"pattern of bytes to fill write payloads with, which are compressed differently; one of "+strings.Join(patternNames, ", "),
+	)
+	flag.IntVar(
+		&c.RowSize,
+		"row_size",
+		c.RowSize,
+		"size of a write payload in bytes before compression",
+	)
+}
+
+func (c Config) Validate() error {
+	return validator.New().Struct(c)
+}
+
+// Codec compresses and decompresses payloads. time spent by the codec is
+// recorded apart from the operation latency, and excluded from the latency of
+// the operation of ctx.
+type Codec struct {
+	name       string
+	Compress   stats.Recorder
+	Decompress stats.Recorder
+}
+
+func NewCodec(conf *Config) *Codec {
+	return &Codec{name: conf.Compress}
+}
+
+// Enabled reports whether payloads are compressed.
+func (c *Codec) Enabled() bool {
+	return c.name != "none"
+}
+
+func (c *Codec) Encode(ctx context.Context, data []byte) (ret []byte, err error) {
+	if !c.Enabled() {
+		return data, nil
+	}
+	start := time.Now()
+	defer func() {
+		d := time.Since(start)
+		c.Compress.Record(err == nil, d)
+		stats.Exclude(ctx, d)
+	}()
+
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+	)
+	switch c.name {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Codec) Decode(ctx context.Context, data []byte) (ret []byte, err error) {
+	if !c.Enabled() {
+		return data, nil
+	}
+	start := time.Now()
+	defer func() {
+		d := time.Since(start)
+		c.Decompress.Record(err == nil, d)
+		stats.Exclude(ctx, d)
+	}()
+
+	var r io.ReadCloser
+	switch c.name {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case "zlib":
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
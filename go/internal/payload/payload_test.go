@@ -0,0 +1,63 @@
+package payload
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		data = bytes.Repeat([]byte("0"), 1<<10)
+	)
+	for _, name := range []string{"none", "gzip", "zlib"} {
+		conf := NewConfig()
+		conf.Compress = name
+		codec := NewCodec(conf)
+
+		encoded, err := codec.Encode(ctx, data)
+		if err != nil {
+			t.Fatalf("%s: Encode() error = %v", name, err)
+		}
+		decoded, err := codec.Decode(ctx, encoded)
+		if err != nil {
+			t.Fatalf("%s: Decode() error = %v", name, err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("%s: Decode(Encode(data)) != data", name)
+		}
+
+		want := 1
+		if name == "none" {
+			want = 0
+		}
+		if codec.Compress.Tries != want || codec.Compress.Ok != want {
+			t.Errorf("%s: Compress tries, ok = %d, %d, want %d", name, codec.Compress.Tries, codec.Compress.Ok, want)
+		}
+		if codec.Decompress.Tries != want || codec.Decompress.Ok != want {
+			t.Errorf("%s: Decompress tries, ok = %d, %d, want %d", name, codec.Decompress.Tries, codec.Decompress.Ok, want)
+		}
+		if name == "none" {
+			continue
+		}
+		if d := codec.Compress.Percentile(50); d <= 0 {
+			t.Errorf("%s: compression time = %v, want positive", name, d)
+		}
+		if len(encoded) >= len(data) {
+			t.Errorf("%s: encoded %d bytes of %d repeated bytes", name, len(encoded), len(data))
+		}
+	}
+}
+
+func TestCodecDecodeError(t *testing.T) {
+	conf := NewConfig()
+	conf.Compress = "gzip"
+	codec := NewCodec(conf)
+	if _, err := codec.Decode(context.Background(), []byte("not gzip")); err == nil {
+		t.Fatal("Decode() error = nil, want error")
+	}
+	if codec.Decompress.Tries != 1 || codec.Decompress.Ok != 0 {
+		t.Errorf("Decompress tries, ok = %d, %d, want 1, 0", codec.Decompress.Tries, codec.Decompress.Ok)
+	}
+}
@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// allocBatchSize is the number of operations between samples of the memory
+// stats, since runtime.ReadMemStats stops the world.
+const allocBatchSize = 1000
+
+// AllocStats is heap allocations of the harness process per operation during
+// a run, including those of the client libraries.
+type AllocStats struct {
+	Ops          int64
+	AllocsPerOp  float64
+	BytesPerOp   float64
+	Batches      int
+	MinBatchRate float64
+	MaxBatchRate float64
+}
+
+func (a *AllocStats) String() string {
+	return fmt.Sprintf(
+		"ops: %d\n"+
+			"allocs/op: %.1f\n"+
+			"bytes/op: %.0f\n"+
+			"allocs/op of batches of %d ops: min %.1f, max %.1f over %d batches\n",
+		a.Ops,
+		a.AllocsPerOp,
+		a.BytesPerOp,
+		allocBatchSize, a.MinBatchRate, a.MaxBatchRate, a.Batches,
+	)
+}
+
+// allocSampler samples memory stats around batches of operations.
+type allocSampler struct {
+	ops int64
+
+	mu          sync.Mutex
+	first, last runtime.MemStats
+	stats       AllocStats
+}
+
+func startAllocSampler() *allocSampler {
+	a := new(allocSampler)
+	runtime.ReadMemStats(&a.first)
+	a.last = a.first
+	return a
+}
+
+// add counts an operation, and samples the memory stats at the end of a batch.
+func (a *allocSampler) add() {
+	if atomic.AddInt64(&a.ops, 1)%allocBatchSize == 0 {
+		a.sample()
+	}
+}
+
+// sample reads the memory stats under a.mu, so that samples are in order of
+// reading, and the number of mallocs never decreases from a.last.
+func (a *allocSampler) sample() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	rate := float64(m.Mallocs-a.last.Mallocs) / allocBatchSize
+	if a.stats.Batches == 0 || rate < a.stats.MinBatchRate {
+		a.stats.MinBatchRate = rate
+	}
+	if rate > a.stats.MaxBatchRate {
+		a.stats.MaxBatchRate = rate
+	}
+	a.stats.Batches++
+	a.last = m
+}
+
+// stop returns the allocations per operation over the run.
+func (a *allocSampler) stop() *AllocStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	a.stats.Ops = atomic.LoadInt64(&a.ops)
+	if a.stats.Ops > 0 {
+		a.stats.AllocsPerOp = float64(m.Mallocs-a.first.Mallocs) / float64(a.stats.Ops)
+		a.stats.BytesPerOp = float64(m.TotalAlloc-a.first.TotalAlloc) / float64(a.stats.Ops)
+	}
+	return &a.stats
+}
@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// allocSink keeps allocations of the test escaping to the heap.
+var allocSink atomic.Value
+
+func TestAllocs(t *testing.T) {
+	allocsPerOp := func(op StatsFunc) *AllocStats {
+		t.Helper()
+		conf := NewConfig()
+		conf.RunFor = 10 * time.Second
+		conf.ReqCount = 4
+		conf.TotalOps = 5 * allocBatchSize
+		conf.WritePercent = 100
+		conf.Allocs = true
+		sts := NewStats(conf)
+		if _, _, err := sts.Start(op, op); err != nil {
+			t.Fatal(err)
+		}
+		if sts.Allocs == nil || sts.Allocs.Ops != int64(conf.TotalOps) {
+			t.Fatalf("allocs = %+v, want %d ops", sts.Allocs, conf.TotalOps)
+		}
+		return sts.Allocs
+	}
+	noop := allocsPerOp(func(ctx context.Context, id int) error { return nil })
+	heavy := allocsPerOp(func(ctx context.Context, id int) error {
+		bufs := make([][]byte, 100)
+		for i := range bufs {
+			bufs[i] = make([]byte, 64)
+		}
+		allocSink.Store(bufs)
+		return nil
+	})
+	if heavy.AllocsPerOp < noop.AllocsPerOp+100 {
+		t.Errorf("allocs/op = %.1f writing, %.1f doing nothing, want at least 100 more writing", heavy.AllocsPerOp, noop.AllocsPerOp)
+	}
+	// every batch of the write-heavy run allocates at least 100 per op, which
+	// an underflowed delta wouldn't.
+	if heavy.Batches == 0 || heavy.MinBatchRate < 100 || heavy.MaxBatchRate > 1e6 {
+		t.Errorf("batches = %d, min %.1f, max %.1f, want every batch at least 100 allocs/op", heavy.Batches, heavy.MinBatchRate, heavy.MaxBatchRate)
+	}
+}
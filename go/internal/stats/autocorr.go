@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/montanaflynn/stats"
+)
+
+// highAutocorrelation is the lag 1 autocorrelation above which latencies of
+// consecutive operations are flagged as coupled.
+const highAutocorrelation = 0.5
+
+// lag1Autocorrelation returns the autocorrelation at lag 1 of xs, which is
+// near 1 if a slow value tends to be followed by a slow value, and near 0 if
+// values are independent. it returns 0 for less than 3 values or constant
+// values.
+func lag1Autocorrelation(xs []float64) float64 {
+	if len(xs) < 3 {
+		return 0
+	}
+	mean, _ := stats.Mean(xs)
+	var num, den float64
+	for i, x := range xs {
+		den += (x - mean) * (x - mean)
+		if i+1 < len(xs) {
+			num += (x - mean) * (xs[i+1] - mean)
+		}
+	}
+	if den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// autocorrelationReport returns the spread of lag 1 autocorrelation of
+// latencies of consecutive operations across workers. a worker with too few
+// operations is left out.
+func autocorrelationReport(seqs [][]float64) string {
+	var values []float64
+	for _, seq := range seqs {
+		if len(seq) >= 3 {
+			values = append(values, lag1Autocorrelation(seq))
+		}
+	}
+	if len(values) == 0 {
+		return "not enough operations\n"
+	}
+
+	var (
+		b         strings.Builder
+		min, _    = stats.Min(values)
+		max, _    = stats.Max(values)
+		median, _ = stats.Median(values)
+		coupled   string
+	)
+	if median > highAutocorrelation {
+		coupled = " (high; slow operations tend to be followed by slow operations)"
+	}
+	fmt.Fprintf(&b, "lag 1 min: %.3f\n", min)
+	fmt.Fprintf(&b, "lag 1 median: %.3f%s\n", median, coupled)
+	fmt.Fprintf(&b, "lag 1 max: %.3f\n", max)
+	return b.String()
+}
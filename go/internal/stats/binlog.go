@@ -0,0 +1,164 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// binlogMagic starts a file written by -binlog.
+var binlogMagic = []byte("GCPSBIN1")
+
+// binlogOps are the ops encoded by their index in records of -binlog.
+var binlogOps = []string{"read", "write", "ryw"}
+
+// binlogRecordSize is the size of an encoded record: relative start and
+// latency as uint32, op as uint8, and ok as a byte.
+const binlogRecordSize = 4 + 4 + 1 + 1
+
+// binlogHeader is written after the magic. start times of records are
+// relative to Started in units of TimeUnit, and latencies are in nanoseconds.
+// a time unit coarser than a nanosecond keeps uint32 start times from
+// overflowing on runs longer than ~4s; with microseconds they cover ~71m.
+type binlogHeader struct {
+	Started  int64 // unix nanoseconds
+	TimeUnit int64 // nanoseconds
+}
+
+// BinlogRecord is a result of an operation read from a -binlog file.
+type BinlogRecord struct {
+	// Start is the start time of the operation relative to the start of the
+	// run.
+	Start time.Duration
+	// Latency saturates at math.MaxUint32 nanoseconds, ~4.3s.
+	Latency time.Duration
+	Op      string
+	Ok      bool
+}
+
+// binlog writes results of all operations to a file in a compact binary
+// format, so long runs can be captured entirely and post-processed.
+type binlog struct {
+	mu       sync.Mutex
+	f        *os.File
+	bw       *bufio.Writer
+	started  time.Time
+	timeUnit time.Duration
+	err      error
+}
+
+func createBinlog(path string, started time.Time) (*binlog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &binlog{f: f, bw: bufio.NewWriter(f), started: started, timeUnit: time.Microsecond}
+	b.bw.Write(binlogMagic)
+	if err := binary.Write(b.bw, binary.LittleEndian, binlogHeader{
+		Started:  started.UnixNano(),
+		TimeUnit: int64(b.timeUnit),
+	}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// add writes a record of an operation started at start. the first error is
+// kept and returned by close.
+func (b *binlog) add(op string, start time.Time, d time.Duration, ok bool) {
+	var rec [binlogRecordSize]byte
+	binary.LittleEndian.PutUint32(rec[0:], saturateUint32(int64(start.Sub(b.started)/b.timeUnit)))
+	binary.LittleEndian.PutUint32(rec[4:], saturateUint32(int64(d)))
+	rec[8] = binlogOp(op)
+	if ok {
+		rec[9] = 1
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		_, b.err = b.bw.Write(rec[:])
+	}
+}
+
+func (b *binlog) close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = b.bw.Flush()
+	}
+	if err := b.f.Close(); b.err == nil {
+		b.err = err
+	}
+	return b.err
+}
+
+func binlogOp(op string) uint8 {
+	for i, o := range binlogOps {
+		if o == op {
+			return uint8(i)
+		}
+	}
+	return math.MaxUint8
+}
+
+func saturateUint32(v int64) uint32 {
+	switch {
+	case v < 0:
+		return 0
+	case v > math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(v)
+}
+
+// ReadBinlog reads records written by -binlog from r, and returns them with
+// the start time of the run.
+func ReadBinlog(r io.Reader) (started time.Time, recs []BinlogRecord, err error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(binlogMagic))
+	if _, err = io.ReadFull(br, magic); err != nil {
+		return
+	}
+	if !bytes.Equal(magic, binlogMagic) {
+		err = errors.New("not a binlog file")
+		return
+	}
+	var h binlogHeader
+	if err = binary.Read(br, binary.LittleEndian, &h); err != nil {
+		return
+	}
+	if h.TimeUnit <= 0 {
+		err = fmt.Errorf("invalid time unit %d in binlog header", h.TimeUnit)
+		return
+	}
+	started = time.Unix(0, h.Started)
+
+	var rec [binlogRecordSize]byte
+	for {
+		if _, err = io.ReadFull(br, rec[:]); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		op := "unknown"
+		if i := int(rec[8]); i < len(binlogOps) {
+			op = binlogOps[i]
+		}
+		recs = append(recs, BinlogRecord{
+			Start:   time.Duration(binary.LittleEndian.Uint32(rec[0:])) * time.Duration(h.TimeUnit),
+			Latency: time.Duration(binary.LittleEndian.Uint32(rec[4:])),
+			Op:      op,
+			Ok:      rec[9] != 0,
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBinlogRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "ops.bin")
+
+	started := time.Unix(1500000000, 123456789)
+	b, err := createBinlog(path, started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.add("read", started.Add(1500*time.Microsecond), 3*time.Millisecond, true)
+	b.add("write", started.Add(2*time.Second+1234), 42*time.Microsecond, false)
+	b.add("ryw", started.Add(time.Minute), time.Nanosecond, true)
+	// latencies saturate at math.MaxUint32 nanoseconds, and starts before
+	// the run at zero.
+	b.add("write", started.Add(-time.Second), 10*time.Second, true)
+	b.add("scan", started, time.Millisecond, true)
+	if err := b.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gotStarted, recs, err := ReadBinlog(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotStarted.Equal(started) {
+		t.Errorf("started = %v, want %v", gotStarted, started)
+	}
+	want := []BinlogRecord{
+		{Start: 1500 * time.Microsecond, Latency: 3 * time.Millisecond, Op: "read", Ok: true},
+		// starts are truncated to microseconds.
+		{Start: 2*time.Second + time.Microsecond, Latency: 42 * time.Microsecond, Op: "write", Ok: false},
+		{Start: time.Minute, Latency: time.Nanosecond, Op: "ryw", Ok: true},
+		{Start: 0, Latency: math.MaxUint32, Op: "write", Ok: true},
+		{Start: 0, Latency: time.Millisecond, Op: "unknown", Ok: true},
+	}
+	if !reflect.DeepEqual(recs, want) {
+		t.Errorf("records = %+v, want %+v", recs, want)
+	}
+}
+
+func TestReadBinlogInvalid(t *testing.T) {
+	if _, _, err := ReadBinlog(bytes.NewReader([]byte("not a binlog file"))); err == nil {
+		t.Error("ReadBinlog() of a wrong magic succeeded")
+	}
+	if _, _, err := ReadBinlog(bytes.NewReader(binlogMagic)); err == nil {
+		t.Error("ReadBinlog() without a header succeeded")
+	}
+}
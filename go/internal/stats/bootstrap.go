@@ -0,0 +1,104 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// bootstrapConfidence is the confidence level of intervals reported by
+// -bootstrap.
+const bootstrapConfidence = 0.95
+
+// interval is the point estimate of a percentile with its confidence interval.
+type interval struct {
+	point  float64
+	lo, hi float64
+}
+
+// bootstrapIntervals returns confidence intervals of percentiles of durations
+// estimated by resampling durations with replacement resamples times. the
+// point estimates and the resamples take the percentiles by nearestRank, so
+// the intervals bracket the point estimates of the same definition.
+func bootstrapIntervals(durations, percentiles []float64, resamples int, rnd *rand.Rand) []interval {
+	var (
+		sorted    = sortedDurations(durations)
+		n         = len(sorted)
+		intervals = make([]interval, len(percentiles))
+	)
+	if n == 0 {
+		return intervals
+	}
+	for j, p := range percentiles {
+		intervals[j].point = sorted[nearestRank(p, n)]
+	}
+	if resamples == 0 {
+		return intervals
+	}
+	var (
+		estimates = make([][]float64, len(percentiles))
+		idx       = make([]int, n)
+	)
+	for r := 0; r < resamples; r++ {
+		// sorting indexes into sorted durations sorts the resample, since
+		// the resample is picked by the indexes.
+		for i := range idx {
+			idx[i] = rnd.Intn(n)
+		}
+		sort.Ints(idx)
+		for j, p := range percentiles {
+			estimates[j] = append(estimates[j], sorted[idx[nearestRank(p, n)]])
+		}
+	}
+
+	alpha := 1 - bootstrapConfidence
+	for j, e := range estimates {
+		sort.Float64s(e)
+		intervals[j].lo = e[nearestRank(alpha/2*100, resamples)]
+		intervals[j].hi = e[nearestRank((1-alpha/2)*100, resamples)]
+	}
+	return intervals
+}
+
+// nearestRank returns the index of the p-th percentile of n sorted values.
+func nearestRank(p float64, n int) int {
+	i := int(math.Ceil(p/100*float64(n))) - 1
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// bootstrapReport returns a table of the percentiles of recorders with their
+// confidence intervals by -bootstrap.
+func (s *Stats) bootstrapReport(recs map[string]*Recorder) string {
+	var (
+		buf bytes.Buffer
+		w   = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		rnd = rand.New(rand.NewSource(s.Config.Seed))
+		ops = make([]string, 0, len(recs))
+	)
+	for op := range recs {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	fmt.Fprintf(w, "op\tmetric\tvalue\t%.0f%% CI\n", bootstrapConfidence*100)
+	for _, op := range ops {
+		intervals := bootstrapIntervals(recs[op].durations, comparePercentiles, s.Config.Bootstrap, rnd)
+		for i, p := range comparePercentiles {
+			iv := intervals[i]
+			fmt.Fprintf(w, "%s\tp%v\t%v\t[%v, %v]\n",
+				op, p, time.Duration(iv.point), time.Duration(iv.lo), time.Duration(iv.hi))
+		}
+	}
+	w.Flush()
+	return buf.String()
+}
@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBootstrapIntervals(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	samples := func(n int) []float64 {
+		durations := make([]float64, n)
+		for i := range durations {
+			durations[i] = rnd.ExpFloat64() * 1e6
+		}
+		return durations
+	}
+	percentiles := []float64{50, 99}
+	width := func(durations []float64) []float64 {
+		var (
+			sorted    = sortedDurations(durations)
+			intervals = bootstrapIntervals(durations, percentiles, 500, rnd)
+			widths    = make([]float64, len(percentiles))
+		)
+		for i, p := range percentiles {
+			point := sorted[nearestRank(p, len(sorted))]
+			if iv := intervals[i]; iv.point != point || iv.lo > point || iv.hi < point {
+				t.Errorf("p%v of %d samples = %v [%v, %v], want the CI to bracket %v", p, len(durations), iv.point, iv.lo, iv.hi, point)
+			}
+			widths[i] = intervals[i].hi - intervals[i].lo
+		}
+		return widths
+	}
+	few, many := width(samples(200)), width(samples(20000))
+	for i, p := range percentiles {
+		if many[i] >= few[i] {
+			t.Errorf("p%v CI width = %v of many samples, want narrower than %v of few", p, many[i], few[i])
+		}
+	}
+}
+
+func TestBootstrapIntervalsEmpty(t *testing.T) {
+	intervals := bootstrapIntervals(nil, []float64{50}, 100, rand.New(rand.NewSource(1)))
+	if len(intervals) != 1 || intervals[0] != (interval{}) {
+		t.Errorf("bootstrapIntervals() of no samples = %v, want a zero interval", intervals)
+	}
+}
+
+func TestBootstrapReportPoint(t *testing.T) {
+	// the p50 of 1ms to 4ms is 2ms by nearest rank as the resamples, not
+	// 2.5ms by interpolation.
+	rec := new(Recorder)
+	for _, d := range []time.Duration{4, 1, 3, 2} {
+		rec.Record(true, d*time.Millisecond)
+	}
+	conf := NewConfig()
+	conf.Bootstrap = 100
+	report := NewStats(conf).bootstrapReport(map[string]*Recorder{"read": rec})
+	var row string
+	for _, line := range strings.Split(report, "\n") {
+		if strings.Contains(line, "p50") {
+			row = strings.Join(strings.Fields(line), " ")
+		}
+	}
+	if !strings.HasPrefix(row, "read p50 2ms [") {
+		t.Errorf("p50 of the report = %q, want the point estimate of 2ms", row)
+	}
+}
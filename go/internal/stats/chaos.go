@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// ErrChaos is the error injected into operations by -chaos_error_rate.
+var ErrChaos = errors.New("chaos: injected error")
+
+// chaos injects latency and errors into calls of operations, to test retries
+// and timeouts of the client without breaking the backend.
+type chaos struct {
+	errorRate   float64
+	latencyRate float64
+	latency     time.Duration
+
+	calls  int64
+	errors int64
+	delays int64
+}
+
+// newChaos returns nil unless -chaos_error_rate or -chaos_latency is set.
+func newChaos(conf *Config) *chaos {
+	if conf.ChaosErrorRate == 0 && conf.ChaosLatency == 0 {
+		return nil
+	}
+	return &chaos{
+		errorRate:   conf.ChaosErrorRate,
+		latencyRate: conf.ChaosLatencyRate,
+		latency:     conf.ChaosLatency,
+	}
+}
+
+// wrap returns f with injected latency and errors. injected latency is
+// interrupted when ctx is done, and injected errors fail calls without
+// calling f.
+func (c *chaos) wrap(f StatsFunc) StatsFunc {
+	return func(ctx context.Context, id int) error {
+		atomic.AddInt64(&c.calls, 1)
+		if c.latency > 0 && rand.Float64() < c.latencyRate {
+			atomic.AddInt64(&c.delays, 1)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.latency):
+			}
+		}
+		if rand.Float64() < c.errorRate {
+			atomic.AddInt64(&c.errors, 1)
+			return ErrChaos
+		}
+		return f(ctx, id)
+	}
+}
+
+func (c *chaos) String() string {
+	return fmt.Sprintf(
+		"injected %d errors and %d delays of %v into %d calls",
+		atomic.LoadInt64(&c.errors), atomic.LoadInt64(&c.delays), c.latency, atomic.LoadInt64(&c.calls),
+	)
+}
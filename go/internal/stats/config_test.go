@@ -0,0 +1,33 @@
+package stats
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRegisterFlagsOf(t *testing.T) {
+	saved := flag.CommandLine
+	defer func() { flag.CommandLine = saved }()
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	flag.CommandLine.SetOutput(ioutil.Discard)
+
+	c := NewConfig()
+	c.RegisterFlagsOf("run_for", "req_count")
+	if err := flag.CommandLine.Parse([]string{"-req_count=3", "-run_for=2s"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.ReqCount != 3 || c.RunFor.String() != "2s" {
+		t.Errorf("ReqCount, RunFor = %d, %v, want 3, 2s", c.ReqCount, c.RunFor)
+	}
+	if f := flag.Lookup("write_percent"); f != nil {
+		t.Errorf("write_percent is registered")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterFlagsOf(unknown) didn't panic")
+		}
+	}()
+	c.RegisterFlagsOf("unknown")
+}
@@ -0,0 +1,19 @@
+package stats
+
+import (
+	"context"
+	"time"
+)
+
+// delayed returns f delayed by d on every call, modeling the network latency
+// to a remote region. the delay is interrupted when ctx is done.
+func delayed(f StatsFunc, d time.Duration) StatsFunc {
+	return func(ctx context.Context, id int) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+		return f(ctx, id)
+	}
+}
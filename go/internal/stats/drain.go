@@ -0,0 +1,37 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drain waits for in-flight operations of wg to finish after the run is
+// stopped. if they don't finish within -drain_timeout, they are canceled by
+// cancel and abandoned, so they aren't recorded and the run ends without
+// waiting for them.
+func (s *Stats) drain(wg *sync.WaitGroup, cancel context.CancelFunc) {
+	timeout := s.Config.DrainTimeout
+	if timeout == 0 {
+		wg.Wait()
+		return
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return
+	case <-time.After(timeout):
+	}
+
+	s.recording.Lock()
+	s.abandoned = true
+	s.recording.Unlock()
+	cancel()
+	log.Printf("Abandoned %d in-flight ops not finished within -drain_timeout %v", atomic.LoadInt64(&s.inFlight), timeout)
+}
@@ -0,0 +1,71 @@
+package stats
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+		wantSlow     bool
+	}{
+		{name: "abandoned", drainTimeout: 100 * time.Millisecond},
+		{name: "wait for all", drainTimeout: 0, wantSlow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.RunFor = time.Hour
+			conf.ReqCount = 2
+			conf.WritePercent = 0
+			conf.DrainTimeout = tt.drainTimeout
+
+			var calls, quick, slow int64
+			// the first operation takes over the grace period, and the others
+			// finish within it.
+			op := func(ctx context.Context, id int) error {
+				if atomic.AddInt64(&calls, 1) == 1 {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-time.After(300 * time.Millisecond):
+					}
+					atomic.AddInt64(&slow, 1)
+					return nil
+				}
+				time.Sleep(30 * time.Millisecond)
+				atomic.AddInt64(&quick, 1)
+				return nil
+			}
+			done := make(chan struct{})
+			s := NewStats(conf)
+			s.Done = done
+			time.AfterFunc(50*time.Millisecond, func() { close(done) })
+
+			start := time.Now()
+			read, _, err := s.Start(op, op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+
+			want := quick
+			if tt.wantSlow {
+				want += slow
+			}
+			if int64(read.Tries) != want || int64(read.Ok) != want {
+				t.Errorf("recorded %d ok / %d tries, want %d finished ops", read.Ok, read.Tries, want)
+			}
+			if got := atomic.LoadInt64(&slow) == 1; got != tt.wantSlow {
+				t.Errorf("slow op finished = %v, want %v", got, tt.wantSlow)
+			}
+			if !tt.wantSlow && elapsed >= 300*time.Millisecond {
+				t.Errorf("run took %v, want it ended after the grace period", elapsed)
+			}
+		})
+	}
+}
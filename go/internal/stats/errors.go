@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidConfig is matched by errors returned on misconfiguration.
+	ErrInvalidConfig = errors.New("stats: invalid config")
+	// ErrAborted is matched by errors returned when a run is aborted before
+	// its end.
+	ErrAborted = errors.New("stats: run aborted")
+	// ErrMissing is returned by Stats.Sweep for a key written during the run
+	// but missing.
+	ErrMissing = errors.New("stats: missing key")
+	// ErrSkipped is returned by an operation which has nothing to do, such as
+	// a read of a key not written yet. the operation isn't recorded, but
+	// counted as skipped.
+	ErrSkipped = errors.New("stats: operation skipped")
+)
+
+// ConfigError wraps an error of config validation.
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrInvalidConfig, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// AbortError is returned when a run is aborted at runtime.
+type AbortError struct {
+	Reason string
+	Err    error
+}
+
+func (e *AbortError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%v: %s", ErrAborted, e.Reason)
+	}
+	return fmt.Sprintf("%v: %s: %v", ErrAborted, e.Reason, e.Err)
+}
+
+func (e *AbortError) Unwrap() error {
+	return e.Err
+}
+
+func (e *AbortError) Is(target error) bool {
+	return target == ErrAborted
+}
+
+// ValidationError is returned by Stats.Validate to fail an operation in a
+// category other than the default "validation".
+type ValidationError struct {
+	Category string
+	Err      error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Category, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
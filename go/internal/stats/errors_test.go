@@ -0,0 +1,45 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestValidateInvalidConfig(t *testing.T) {
+	if err := NewConfig().Validate(); err != nil {
+		t.Fatalf("Validate() of the default config = %v", err)
+	}
+	c := NewConfig()
+	c.ReqCount = 0
+	err := c.Validate()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Validate() = %v, want ErrInvalidConfig", err)
+	}
+	if errors.Is(err, ErrAborted) {
+		t.Errorf("Validate() = %v, matches ErrAborted", err)
+	}
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) || cerr.Err == nil {
+		t.Errorf("Validate() = %v, want a *ConfigError with the cause", err)
+	}
+}
+
+func TestAbortError(t *testing.T) {
+	var (
+		backendErr = errors.New("backend gone")
+		err        = fmt.Errorf("run a: %w", &AbortError{Reason: "fail fast", Err: backendErr})
+	)
+	if !errors.Is(err, ErrAborted) {
+		t.Errorf("error %v doesn't match ErrAborted", err)
+	}
+	if !errors.Is(err, backendErr) {
+		t.Errorf("error %v doesn't wrap the backend error", err)
+	}
+	if errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("error %v matches ErrInvalidConfig", err)
+	}
+	if err := (&AbortError{Reason: "interrupted"}); !errors.Is(err, ErrAborted) || err.Error() != "stats: run aborted: interrupted" {
+		t.Errorf("error %q, want an ErrAborted with the reason", err)
+	}
+}
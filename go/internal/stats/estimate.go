@@ -0,0 +1,55 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Projection is the projected load of a run printed by -estimate.
+type Projection struct {
+	Ops          int64
+	Reads        int64
+	Writes       int64
+	BytesRead    int64
+	BytesWritten int64
+}
+
+// Project returns the load of a run projected by -run_for, -target_qps,
+// -total_ops and the mix of operations, with rows of rowSize bytes. a read of
+// read-your-writes is counted as a read, and its write as a write. the ops are
+// unbounded without -target_qps or -total_ops.
+func (c *Config) Project(rowSize int) (*Projection, error) {
+	var ops int64
+	if c.TargetQPS > 0 {
+		ops = int64(c.RunFor.Seconds() * float64(c.TargetQPS))
+	}
+	if total := int64(c.TotalOps); total > 0 && (ops == 0 || total < ops) {
+		ops = total
+	}
+	if ops == 0 {
+		return nil, errors.New("ops are unbounded without -target_qps or -total_ops")
+	}
+	p := &Projection{
+		Ops:    ops,
+		Writes: ops * int64(c.WritePercent+c.RYWPercent) / 100,
+		Reads:  ops * int64(100-c.WritePercent) / 100,
+	}
+	p.BytesWritten = p.Writes * int64(rowSize)
+	p.BytesRead = p.Reads * int64(rowSize)
+	return p, nil
+}
+
+func (p *Projection) String() string {
+	return fmt.Sprintf(
+		"ops: %d\n"+
+			"reads: %d\n"+
+			"writes: %d\n"+
+			"bytes read: %d (%.1f MiB)\n"+
+			"bytes written: %d (%.1f MiB)\n",
+		p.Ops,
+		p.Reads,
+		p.Writes,
+		p.BytesRead, float64(p.BytesRead)/(1<<20),
+		p.BytesWritten, float64(p.BytesWritten)/(1<<20),
+	)
+}
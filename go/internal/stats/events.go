@@ -0,0 +1,72 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// event is a result of an operation written to -events_file.
+type event struct {
+	Op       string        `json:"op"`
+	ID       int           `json:"id"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Ok       bool          `json:"ok"`
+}
+
+// reservoir keeps a uniform sample of at most size events out of all added
+// events, so memory is bounded regardless of the length of the run. size 0
+// keeps all events.
+type reservoir struct {
+	mu     sync.Mutex
+	size   int
+	seen   int64
+	events []event
+}
+
+func newReservoir(size int) *reservoir {
+	return &reservoir{size: size}
+}
+
+func (r *reservoir) add(e event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seen++
+	if r.size == 0 || len(r.events) < r.size {
+		r.events = append(r.events, e)
+		return
+	}
+	// replace a kept event with probability size/seen, which keeps every
+	// event seen so far in the sample with the same probability.
+	if i := rand.Int63n(r.seen); i < int64(r.size) {
+		r.events[i] = e
+	}
+}
+
+// writeFile writes the kept events to path as JSON lines.
+func (r *reservoir) writeFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	var (
+		bw  = bufio.NewWriter(f)
+		enc = json.NewEncoder(bw)
+	)
+	for _, e := range r.events {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
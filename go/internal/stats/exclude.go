@@ -0,0 +1,44 @@
+package stats
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type excludedKey struct{}
+
+// excluded is time spent by an operation outside of the backend, such as by
+// client side compression, which is excluded from the latency of the
+// operation.
+type excluded struct {
+	d int64
+}
+
+// withExcluded returns ctx of an operation, which accumulates time passed to
+// Exclude.
+func withExcluded(ctx context.Context) (context.Context, *excluded) {
+	e := new(excluded)
+	return context.WithValue(ctx, excludedKey{}, e), e
+}
+
+func (e *excluded) get() time.Duration {
+	return time.Duration(atomic.LoadInt64(&e.d))
+}
+
+// excludedIn returns time excluded so far by the operation of ctx.
+func excludedIn(ctx context.Context) time.Duration {
+	if e, ok := ctx.Value(excludedKey{}).(*excluded); ok {
+		return e.get()
+	}
+	return 0
+}
+
+// Exclude excludes d spent on the client, such as by compression of a
+// payload, from the latency of the operation of ctx. it does nothing if ctx
+// isn't of an operation run by the harness.
+func Exclude(ctx context.Context, d time.Duration) {
+	if e, ok := ctx.Value(excludedKey{}).(*excluded); ok {
+		atomic.AddInt64(&e.d, int64(d))
+	}
+}
@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExclude(t *testing.T) {
+	// outside of an operation, it does nothing.
+	Exclude(context.Background(), time.Second)
+	if d := excludedIn(context.Background()); d != 0 {
+		t.Errorf("excludedIn(Background) = %v, want 0", d)
+	}
+
+	ctx, e := withExcluded(context.Background())
+	Exclude(ctx, time.Millisecond)
+	Exclude(ctx, 2*time.Millisecond)
+	if d := e.get(); d != 3*time.Millisecond {
+		t.Errorf("excluded = %v, want 3ms", d)
+	}
+	if d := excludedIn(ctx); d != 3*time.Millisecond {
+		t.Errorf("excludedIn() = %v, want 3ms", d)
+	}
+}
+
+// runReads runs reads by f for a short while, and returns the median latency.
+func runReads(t *testing.T, f StatsFunc) time.Duration {
+	conf := NewConfig()
+	conf.RunFor = 300 * time.Millisecond
+	conf.ReqCount = 1
+	conf.WritePercent = 0
+	read, _, err := NewStats(conf).Start(f, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Ok == 0 {
+		t.Fatal("no reads are recorded")
+	}
+	return read.Percentile(50)
+}
+
+func TestStartExcludesTime(t *testing.T) {
+	const work = 20 * time.Millisecond
+	included := runReads(t, func(ctx context.Context, id int) error {
+		time.Sleep(work)
+		return nil
+	})
+	excluded := runReads(t, func(ctx context.Context, id int) error {
+		start := time.Now()
+		time.Sleep(work)
+		Exclude(ctx, time.Since(start))
+		return nil
+	})
+	if included < work {
+		t.Errorf("p50 without Exclude = %v, want at least %v", included, work)
+	}
+	if excluded > work/2 {
+		t.Errorf("p50 with the work excluded = %v, want less than %v", excluded, work/2)
+	}
+}
@@ -0,0 +1,41 @@
+package stats
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fatalCodes are gRPC codes which won't be resolved by retrying, such as
+// denied credentials or a missing table.
+var fatalCodes = map[codes.Code]bool{
+	codes.Unauthenticated:  true,
+	codes.PermissionDenied: true,
+	codes.NotFound:         true,
+}
+
+// IsFatalStatus reports whether err is a gRPC status with a fatal code. it's
+// used to abort a run under -fail_fast unless Stats.Fatal is set.
+func IsFatalStatus(err error) bool {
+	st, ok := status.FromError(err)
+	return ok && fatalCodes[st.Code()]
+}
+
+// fatal reports whether err should abort the run under -fail_fast.
+func (s *Stats) fatal(err error) bool {
+	if s.Fatal != nil {
+		return s.Fatal(err)
+	}
+	return IsFatalStatus(err)
+}
+
+// failFast aborts the run by err if -fail_fast is set and err is fatal. only
+// the first fatal error is kept.
+func (s *Stats) failFast(err error) {
+	if !s.Config.FailFast || !s.fatal(err) {
+		return
+	}
+	select {
+	case s.abort <- err:
+	default:
+	}
+}
@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFailFast(t *testing.T) {
+	denied := status.Error(codes.PermissionDenied, "denied")
+	op := func(ctx context.Context, id int) error {
+		time.Sleep(time.Millisecond)
+		return denied
+	}
+
+	conf := NewConfig()
+	conf.RunFor = time.Minute
+	conf.ReqCount = 2
+	conf.FailFast = true
+	start := time.Now()
+	_, _, err := NewStats(conf).Start(op, op)
+	if !errors.Is(err, ErrAborted) || !errors.Is(err, denied) {
+		t.Errorf("Start() = %v, want ErrAborted by the denied error", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("Start() took %v, want to abort immediately", d)
+	}
+
+	conf = NewConfig()
+	conf.RunFor = 50 * time.Millisecond
+	conf.ReqCount = 2
+	read, write, err := NewStats(conf).Start(op, op)
+	if err != nil {
+		t.Errorf("Start() without -fail_fast = %v, want nil", err)
+	}
+	if n := read.Tries + write.Tries; n == 0 || read.Ok+write.Ok != 0 {
+		t.Errorf("%d ok / %d tries, want failed ops counted", read.Ok+write.Ok, n)
+	}
+}
+
+func TestFailFastNotFatal(t *testing.T) {
+	conf := NewConfig()
+	conf.RunFor = 50 * time.Millisecond
+	conf.ReqCount = 2
+	conf.FailFast = true
+	op := func(ctx context.Context, id int) error {
+		time.Sleep(time.Millisecond)
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+	if _, _, err := NewStats(conf).Start(op, op); err != nil {
+		t.Errorf("Start() = %v, want nil on a retryable error", err)
+	}
+}
+
+func TestStartAborted(t *testing.T) {
+	conf := NewConfig()
+	conf.RunFor = time.Minute
+	conf.ReqCount = 2
+	conf.FailFast = true
+	var (
+		backendErr = errors.New("backend gone")
+		s          = NewStats(conf)
+		op         = func(ctx context.Context, id int) error { return backendErr }
+	)
+	s.Fatal = func(err error) bool { return err == backendErr }
+	_, _, err := s.Start(op, op)
+	if !errors.Is(err, ErrAborted) {
+		t.Errorf("Start() = %v, want ErrAborted", err)
+	}
+	if !errors.Is(err, backendErr) {
+		t.Errorf("Start() = %v, want it to wrap the backend error", err)
+	}
+	if errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Start() = %v, matches ErrInvalidConfig", err)
+	}
+}
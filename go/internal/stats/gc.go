@@ -0,0 +1,123 @@
+package stats
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const gcSampleInterval = 500 * time.Millisecond
+
+// GCStats is GC activity of the harness process during a run.
+type GCStats struct {
+	NumGC      uint32
+	PauseTotal time.Duration
+	MaxPause   time.Duration
+	// SlowOps is the number of operations slower than the 99th percentile of
+	// its kind, and SlowOpsInGC is the number of them overlapping GC pauses.
+	SlowOps     int
+	SlowOpsInGC int
+}
+
+func (g *GCStats) String() string {
+	return fmt.Sprintf(
+		"GC cycles: %d\n"+
+			"total pause: %v\n"+
+			"max pause: %v\n"+
+			"slow ops overlapping GC pauses: %d / %d\n",
+		g.NumGC,
+		g.PauseTotal,
+		g.MaxPause,
+		g.SlowOpsInGC, g.SlowOps,
+	)
+}
+
+type gcPause struct {
+	start, end time.Time
+}
+
+// gcSampler samples GC pauses periodically, since runtime.MemStats keeps
+// only the recent 256 pauses.
+type gcSampler struct {
+	stats  GCStats
+	pauses []gcPause
+	last   uint32
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func startGCSampler(interval time.Duration) *gcSampler {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	g := &gcSampler{
+		last: m.NumGC,
+		done: make(chan struct{}),
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-g.done:
+				return
+			case <-ticker.C:
+				g.sample()
+			}
+		}
+	}()
+	return g
+}
+
+func (g *gcSampler) sample() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	from := g.last + 1
+	if m.NumGC > uint32(len(m.PauseNs)) && from < m.NumGC-uint32(len(m.PauseNs))+1 {
+		// pauses older than the ring buffer are lost.
+		from = m.NumGC - uint32(len(m.PauseNs)) + 1
+	}
+	for n := from; n <= m.NumGC; n++ {
+		var (
+			i     = (n + uint32(len(m.PauseNs)) - 1) % uint32(len(m.PauseNs))
+			pause = time.Duration(m.PauseNs[i])
+			end   = time.Unix(0, int64(m.PauseEnd[i]))
+		)
+		g.stats.PauseTotal += pause
+		if pause > g.stats.MaxPause {
+			g.stats.MaxPause = pause
+		}
+		g.pauses = append(g.pauses, gcPause{start: end.Add(-pause), end: end})
+	}
+	g.stats.NumGC += m.NumGC - g.last
+	g.last = m.NumGC
+}
+
+// stop stops sampling and returns GC stats with overlaps of slow operations
+// of the recorders.
+func (g *gcSampler) stop(recs ...*Recorder) *GCStats {
+	close(g.done)
+	g.wg.Wait()
+	g.sample()
+
+	for _, rec := range recs {
+		p99 := rec.Percentile(99)
+		for i, d := range rec.durations {
+			if time.Duration(d) <= p99 {
+				continue
+			}
+			g.stats.SlowOps++
+			start := rec.starts[i]
+			end := start.Add(time.Duration(d))
+			for _, pause := range g.pauses {
+				if start.Before(pause.end) && pause.start.Before(end) {
+					g.stats.SlowOpsInGC++
+					break
+				}
+			}
+		}
+	}
+	return &g.stats
+}
@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const heartbeatInterval = 5 * time.Second
+
+// health serves /healthz which returns 200 while the run is active and the
+// last heartbeat of the backend succeeded, or 503 otherwise.
+type health struct {
+	active  int32
+	healthy int32
+	server  *http.Server
+	done    chan struct{}
+}
+
+func startHealth(addr string, heartbeat func(context.Context) error) *health {
+	h := &health{
+		active:  1,
+		healthy: 1,
+		done:    make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.serveHTTP)
+	h.server = &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := h.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error serving health: %v", err)
+		}
+	}()
+	if heartbeat != nil {
+		go h.beat(heartbeat)
+	}
+	return h
+}
+
+func (h *health) beat(heartbeat func(context.Context) error) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), heartbeatInterval)
+		err := heartbeat(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Error on heartbeat: %v", err)
+			atomic.StoreInt32(&h.healthy, 0)
+		} else {
+			atomic.StoreInt32(&h.healthy, 1)
+		}
+
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *health) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&h.active) == 1 && atomic.LoadInt32(&h.healthy) == 1 {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte("unavailable\n"))
+}
+
+func (h *health) stop() {
+	atomic.StoreInt32(&h.active, 0)
+	close(h.done)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	h.server.Shutdown(ctx)
+}
@@ -0,0 +1,120 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/montanaflynn/stats"
+)
+
+// hgrmTicksPerHalfDistance is the number of percentile lines reported per
+// half distance to 100%, same as the default of HdrHistogram.
+const hgrmTicksPerHalfDistance = 5
+
+// writeHgrms writes the distributions of recorders to files named after the
+// -hgrm_file flag with the op, such as "out.read.hgrm" for "out.hgrm".
+func (s *Stats) writeHgrms(recs map[string]*Recorder) error {
+	var (
+		ext  = filepath.Ext(s.Config.HgrmFile)
+		base = strings.TrimSuffix(s.Config.HgrmFile, ext)
+	)
+	for op, rec := range recs {
+		if err := writeHgrmFile(fmt.Sprintf("%s.%s%s", base, op, ext), rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHgrmFile(path string, rec *Recorder) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeHgrm(f, rec.durations); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// hgrmPoint is a line of the percentile distribution.
+type hgrmPoint struct {
+	value      float64
+	percentile float64
+	count      int
+}
+
+// hgrmPoints returns the percentile distribution of sorted durations, with
+// more points towards the tail as HdrHistogram does. the last point is
+// always the max at 100%.
+func hgrmPoints(sorted []float64) []hgrmPoint {
+	var (
+		n      = len(sorted)
+		points []hgrmPoint
+	)
+	for percentile := 0.0; n > 0; {
+		count := int(math.Ceil(percentile / 100 * float64(n)))
+		if count < 1 {
+			count = 1
+		}
+		if count >= n {
+			break
+		}
+		points = append(points, hgrmPoint{value: sorted[count-1], percentile: percentile, count: count})
+
+		ticks := hgrmTicksPerHalfDistance * math.Pow(2, math.Floor(math.Log2(100/(100-percentile)))+1)
+		percentile += 100 / ticks
+	}
+	if n > 0 {
+		points = append(points, hgrmPoint{value: sorted[n-1], percentile: 100, count: n})
+	}
+	return points
+}
+
+// sortedDurations returns a sorted copy of durations.
+func sortedDurations(durations []float64) []float64 {
+	sorted := append([]float64(nil), durations...)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+// writeHgrm writes durations in the percentile distribution format of
+// HdrHistogram, with values in milliseconds.
+func writeHgrm(w io.Writer, durations []float64) error {
+	var (
+		bw     = bufio.NewWriter(w)
+		sorted = sortedDurations(durations)
+		n      = len(sorted)
+		ms     = func(d float64) float64 { return d / float64(time.Millisecond) }
+	)
+
+	fmt.Fprintf(bw, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+	for _, p := range hgrmPoints(sorted) {
+		if p.count == n {
+			fmt.Fprintf(bw, "%12.3f %2.12f %10d\n", ms(p.value), 1.0, n)
+			continue
+		}
+		fmt.Fprintf(bw, "%12.3f %2.12f %10d %14.2f\n",
+			ms(p.value), p.percentile/100, p.count, 1/(1-p.percentile/100))
+	}
+
+	var (
+		mean, _   = stats.Mean(sorted)
+		stddev, _ = stats.StandardDeviation(sorted)
+		max       float64
+	)
+	if n > 0 {
+		max = sorted[n-1]
+	}
+	fmt.Fprintf(bw, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", ms(mean), ms(stddev))
+	fmt.Fprintf(bw, "#[Max     = %12.3f, Total count    = %12d]\n", ms(max), n)
+	return bw.Flush()
+}
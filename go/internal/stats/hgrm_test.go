@@ -0,0 +1,105 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type hgrmLine struct {
+	value, percentile float64
+	count             int
+}
+
+// parseHgrm parses the percentile distribution format of HdrHistogram.
+func parseHgrm(t *testing.T, b []byte) (lines []hgrmLine, footer []string) {
+	t.Helper()
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	if !sc.Scan() || strings.Join(strings.Fields(sc.Text()), " ") != "Value Percentile TotalCount 1/(1-Percentile)" {
+		t.Fatalf("header = %q, want the HdrHistogram header", sc.Text())
+	}
+	if !sc.Scan() || sc.Text() != "" {
+		t.Fatalf("line after the header = %q, want an empty line", sc.Text())
+	}
+	for sc.Scan() {
+		if strings.HasPrefix(sc.Text(), "#[") {
+			footer = append(footer, sc.Text())
+			continue
+		}
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 3 && len(fields) != 4 {
+			t.Fatalf("line %q has %d fields, want 3 or 4", sc.Text(), len(fields))
+		}
+		value, verr := strconv.ParseFloat(fields[0], 64)
+		percentile, perr := strconv.ParseFloat(fields[1], 64)
+		count, cerr := strconv.Atoi(fields[2])
+		if verr != nil || perr != nil || cerr != nil {
+			t.Fatalf("line %q isn't numeric", sc.Text())
+		}
+		lines = append(lines, hgrmLine{value: value, percentile: percentile, count: count})
+	}
+	return lines, footer
+}
+
+func TestWriteHgrm(t *testing.T) {
+	var durations []float64
+	for i := 1000; i >= 1; i-- {
+		durations = append(durations, float64(time.Duration(i)*time.Millisecond))
+	}
+	var buf bytes.Buffer
+	if err := writeHgrm(&buf, durations); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, footer := parseHgrm(t, buf.Bytes())
+	if len(lines) < 10 {
+		t.Fatalf("%d percentile lines, want more", len(lines))
+	}
+	for i := 1; i < len(lines); i++ {
+		if lines[i].value < lines[i-1].value || lines[i].percentile <= lines[i-1].percentile || lines[i].count < lines[i-1].count {
+			t.Errorf("line %d = %+v after %+v, want increasing", i, lines[i], lines[i-1])
+		}
+	}
+	if last := lines[len(lines)-1]; last.value != 1000 || last.percentile != 1 || last.count != 1000 {
+		t.Errorf("last line = %+v, want the max at 100%%", last)
+	}
+	for _, l := range lines {
+		if l.percentile >= 0.4999 {
+			if l.value < 495 || l.value > 505 {
+				t.Errorf("value at %v = %vms, want about 500ms", l.percentile, l.value)
+			}
+			break
+		}
+	}
+	if len(footer) != 2 || !strings.Contains(footer[0], "Mean") || !strings.Contains(footer[1], "Total count    =         1000") {
+		t.Errorf("footer = %q, want the mean and the total count", footer)
+	}
+}
+
+func TestWriteHgrms(t *testing.T) {
+	var (
+		dir  = writeTestFiles(t, nil)
+		s    = NewStats(NewConfig())
+		rec  Recorder
+		want = map[string]string{"read": "out.read.hgrm", "write": "out.write.hgrm"}
+	)
+	rec.Record(true, time.Millisecond)
+	s.Config.HgrmFile = filepath.Join(dir, "out.hgrm")
+	if err := s.writeHgrms(map[string]*Recorder{"read": &rec, "write": &rec}); err != nil {
+		t.Fatal(err)
+	}
+	for op, name := range want {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("hgrm of %s: %v", op, err)
+		}
+		if lines, _ := parseHgrm(t, b); len(lines) != 1 || lines[0].count != 1 {
+			t.Errorf("hgrm of %s = %+v, want the only duration", op, lines)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package stats
+
+import (
+	"errors"
+	"time"
+)
+
+// errNoHistory is returned by Start if -history_db is set but the command
+// doesn't set Stats.AppendHistory.
+var errNoHistory = errors.New("-history_db isn't supported by this command")
+
+// HistoryRun is the flags and the summary of a run appended to -history_db.
+type HistoryRun struct {
+	Started   time.Time
+	Flags     map[string]string
+	Passed    bool
+	TotalOps  int
+	ErrorRate float64
+	ReadP99   float64
+	WriteP99  float64
+}
+
+// appendHistory appends the flags and the summary of the run to -history_db
+// by s.AppendHistory. the flags are of s.Config, so each run of -runs has its
+// own values.
+func (s *Stats) appendHistory(read, write *Recorder, aborted bool) error {
+	sum := s.newExitSummary(read, write, aborted)
+	return s.AppendHistory(s.Config.HistoryDB, &HistoryRun{
+		Started:   s.started,
+		Flags:     s.Config.flagValues(),
+		Passed:    sum.Passed,
+		TotalOps:  sum.TotalOps,
+		ErrorRate: sum.ErrorRate,
+		ReadP99:   sum.ReadP99,
+		WriteP99:  sum.WriteP99,
+	})
+}
@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"context"
+	"flag"
+	"testing"
+	"time"
+)
+
+func TestAppendHistoryRecordsConfig(t *testing.T) {
+	var runs []*HistoryRun
+	withCommandLine(t, []string{"-pass=s3cret", "-write_percent=90"}, func() {
+		flag.String("pass", "", "password")
+		flag.Int("write_percent", 0, "write percent of another config")
+		if err := flag.CommandLine.Parse(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		conf := NewConfig()
+		conf.RunFor = 10 * time.Millisecond
+		conf.ReqCount = 1
+		conf.WritePercent = 30
+		conf.HistoryDB = "history.db"
+		sts := NewStats(conf)
+		sts.AppendHistory = func(path string, run *HistoryRun) error {
+			runs = append(runs, run)
+			return nil
+		}
+		op := func(ctx context.Context, id int) error { return nil }
+		if _, _, err := sts.Start(op, op); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if len(runs) != 1 {
+		t.Fatalf("appended %d runs, want 1", len(runs))
+	}
+	flags := runs[0].Flags
+	if got := flags["write_percent"]; got != "30" {
+		t.Errorf("write_percent = %q, want 30 of the config of the run", got)
+	}
+	if got := flags["run_for"]; got != "10ms" {
+		t.Errorf("run_for = %q, want 10ms", got)
+	}
+	if _, ok := flags["pass"]; ok {
+		t.Errorf("flags have pass = %q, which isn't of the config", flags["pass"])
+	}
+}
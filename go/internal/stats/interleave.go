@@ -0,0 +1,162 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"text/tabwriter"
+	"time"
+
+	"github.com/montanaflynn/stats"
+)
+
+// round is a result of a run in a round of interleaved runs.
+type round struct {
+	read  *Recorder
+	write *Recorder
+}
+
+// startInterleaved runs confs alternately in -interleave rounds, each for
+// -run_for divided by the rounds. the order is reversed in every other round
+// (ABBA), so linear drift of the backend affects each run equally. the files
+// written by a round are suffixed by the name of the run and the round.
+func (s *Stats) startInterleaved(confs []namedConfig, readFunc, writeFunc StatsFunc) ([]Run, []exitSummary, error) {
+	var (
+		rounds = s.Config.Interleave
+		runs   = make([]Run, len(confs))
+		sums   []exitSummary
+	)
+	for i, c := range confs {
+		runs[i] = Run{Name: c.name, Read: new(Recorder), Write: new(Recorder)}
+	}
+	for r := 0; r < rounds; r++ {
+		for k := range confs {
+			i := k
+			if r%2 == 1 {
+				i = len(confs) - 1 - k
+			}
+			c := confs[i]
+			conf := *c.conf
+			conf.RunFor = c.conf.RunFor / time.Duration(rounds)
+
+			log.Printf("Start run %s (round %d/%d)", c.name, r+1, rounds)
+			res, err := s.startRun(&conf, fmt.Sprintf("%s.round%d", c.name, r+1), readFunc, writeFunc)
+			if res != nil {
+				sums = append(sums, res.sum)
+			}
+			if err != nil {
+				return nil, sums, fmt.Errorf("run %s round %d: %w", c.name, r+1, err)
+			}
+			runs[i].Read.merge(res.read)
+			runs[i].Write.merge(res.write)
+			runs[i].rounds = append(runs[i].rounds, round{read: res.read, write: res.write})
+			if res.signaled {
+				log.Printf("Skipping the rest of rounds by the signal")
+				return startedRuns(runs), sums, nil
+			}
+		}
+	}
+	return runs, sums, nil
+}
+
+// startedRuns returns runs with at least a round. runs are started in order
+// in the first round, so they're a prefix of runs.
+func startedRuns(runs []Run) []Run {
+	for i, run := range runs {
+		if len(run.rounds) == 0 {
+			return runs[:i]
+		}
+	}
+	return runs
+}
+
+var verdictPercentiles = []float64{50, 95, 99}
+
+// Verdict returns a table comparing interleaved runs with the first run as
+// the baseline. the difference of each percentile is paired by round, and
+// reported with its 95% confidence interval by Student's t distribution, as
+// there are only a few rounds; the difference is significant
+// if the interval doesn't contain 0. it returns an empty string unless the
+// runs were interleaved in 2 or more rounds.
+func Verdict(runs []Run) string {
+	if len(runs) < 2 || len(runs[0].rounds) < 2 {
+		return ""
+	}
+	var (
+		buf bytes.Buffer
+		w   = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	)
+	fmt.Fprintln(w, "run\top\tpercentile\tdiff\t95% CI\tverdict")
+	for _, run := range runs[1:] {
+		for _, op := range []string{"read", "write"} {
+			for _, p := range verdictPercentiles {
+				diffs := roundDiffs(runs[0].rounds, run.rounds, op, p)
+				if len(diffs) < 2 {
+					continue
+				}
+				var (
+					mean, _ = stats.Mean(diffs)
+					sd, _   = stats.StandardDeviationSample(diffs)
+					ci      = tQuantile975(len(diffs)-1) * sd / math.Sqrt(float64(len(diffs)))
+					verdict = "no significant difference"
+				)
+				switch {
+				case mean-ci > 0:
+					verdict = "slower"
+				case mean+ci < 0:
+					verdict = "faster"
+				}
+				fmt.Fprintf(w, "%s\t%s\tp%v\t%+.1f%%\t±%.1f%%\t%s\n", run.Name, op, p, mean, ci, verdict)
+			}
+		}
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// tQuantiles975 are 0.975 quantiles of Student's t distribution by degrees of
+// freedom from 1 to 30.
+var tQuantiles975 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// tQuantile975 returns the 0.975 quantile of Student's t distribution with df
+// degrees of freedom, for a two-sided 95% confidence interval. beyond the
+// table, the quantile of the nearest smaller tabulated df is returned, which
+// is slightly wider than exact.
+func tQuantile975(df int) float64 {
+	switch {
+	case df <= len(tQuantiles975):
+		return tQuantiles975[df-1]
+	case df < 60:
+		return 2.021 // 40
+	case df < 120:
+		return 2.000 // 60
+	default:
+		return 1.980 // 120
+	}
+}
+
+// roundDiffs returns the difference in percent of the p-th percentile of op
+// from base to exp for each round. rounds without the op are skipped.
+func roundDiffs(base, exp []round, op string, p float64) []float64 {
+	var diffs []float64
+	for k := 0; k < len(base) && k < len(exp); k++ {
+		b, e := base[k].read, exp[k].read
+		if op == "write" {
+			b, e = base[k].write, exp[k].write
+		}
+		if len(b.durations) == 0 || len(e.durations) == 0 {
+			continue
+		}
+		bp, ep := float64(b.Percentile(p)), float64(e.Percentile(p))
+		if bp == 0 {
+			continue
+		}
+		diffs = append(diffs, (ep-bp)/bp*100)
+	}
+	return diffs
+}
@@ -0,0 +1,53 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTQuantile975(t *testing.T) {
+	for _, c := range []struct {
+		df   int
+		want float64
+	}{
+		{df: 1, want: 12.706},
+		{df: 2, want: 4.303},
+		{df: 30, want: 2.042},
+		{df: 45, want: 2.021},
+		{df: 1000, want: 1.980},
+	} {
+		if got := tQuantile975(c.df); got != c.want {
+			t.Errorf("tQuantile975(%d) = %v, want %v", c.df, got, c.want)
+		}
+	}
+}
+
+// testRounds returns rounds with a read of each duration.
+func testRounds(durations ...time.Duration) []round {
+	rounds := make([]round, len(durations))
+	for i, d := range durations {
+		rounds[i] = round{read: new(Recorder), write: new(Recorder)}
+		rounds[i].read.Record(true, d)
+	}
+	return rounds
+}
+
+func TestVerdictFewRounds(t *testing.T) {
+	// the diffs are +10%, +20% and +3%; their mean is 11% with the standard
+	// error of 4.9%, which is significant by the normal quantile of 1.96 but
+	// not by the t quantile of 4.303 with 2 degrees of freedom.
+	runs := []Run{
+		{Name: "base", rounds: testRounds(100*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond)},
+		{Name: "exp", rounds: testRounds(110*time.Millisecond, 120*time.Millisecond, 103*time.Millisecond)},
+	}
+	var row string
+	for _, line := range strings.Split(Verdict(runs), "\n") {
+		if strings.Contains(line, "p50") {
+			row = line
+		}
+	}
+	if !strings.Contains(row, "±21.2%") || !strings.Contains(row, "no significant difference") {
+		t.Errorf("verdict of p50 = %q, want ±21.2%% and no significant difference", row)
+	}
+}
@@ -0,0 +1,37 @@
+package stats
+
+import (
+	"math/rand"
+	"time"
+)
+
+// keySpace returns the number of keys operations are spread over at
+// elapsed since the start of the run. it grows linearly from -keys to
+// -final_keys over -run_for if -final_keys is set.
+func (c *Config) keySpace(elapsed time.Duration) int {
+	if c.FinalKeys == 0 || c.RunFor <= 0 {
+		return c.Keys
+	}
+	if elapsed >= c.RunFor {
+		return c.FinalKeys
+	}
+	grown := float64(c.FinalKeys-c.Keys) * float64(elapsed) / float64(c.RunFor)
+	return c.Keys + int(grown)
+}
+
+// pickKey returns a random id in the current key space, or in the range of
+// the key space owned by the worker under -key_affinity.
+func (s *Stats) pickKey(worker int) int {
+	keys := s.Config.keySpace(time.Since(s.started))
+	if !s.Config.KeyAffinity {
+		return rand.Intn(keys)
+	}
+	lo, hi := keyRange(keys, worker, s.Config.ReqCount)
+	return lo + rand.Intn(hi-lo)
+}
+
+// keyRange returns the range [lo, hi) of keys owned by the worker out of n
+// workers. every worker owns at least a key if keys >= n.
+func keyRange(keys, worker, n int) (lo, hi int) {
+	return keys * worker / n, keys * (worker + 1) / n
+}
@@ -0,0 +1,42 @@
+package stats
+
+import (
+	"os"
+)
+
+// labels of metrics exported by any sink.
+const (
+	LabelBackend  = "backend"
+	LabelOp       = "op"
+	LabelResult   = "result"
+	LabelInstance = "instance"
+)
+
+// Labels returns the labels of metrics of op with result, so every exporter
+// labels them consistently. op and result are omitted if empty, for metrics
+// not broken down by them. the instance is the hostname unless
+// -metrics_instance is set.
+func (c *Config) Labels(op, result string) map[string]string {
+	labels := map[string]string{
+		LabelBackend:  c.Backend,
+		LabelInstance: c.MetricsInstance,
+	}
+	if labels[LabelInstance] == "" {
+		labels[LabelInstance] = hostname()
+	}
+	if op != "" {
+		labels[LabelOp] = op
+	}
+	if result != "" {
+		labels[LabelResult] = result
+	}
+	return labels
+}
+
+func hostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
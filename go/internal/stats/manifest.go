@@ -0,0 +1,153 @@
+package stats
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// manifestEnvs are environment variables which change behavior of clients.
+var manifestEnvs = []string{
+	"GOOGLE_APPLICATION_CREDENTIALS",
+	"GOOGLE_CLOUD_PROJECT",
+	"BIGTABLE_EMULATOR_HOST",
+	"GOMAXPROCS",
+	"GOGC",
+}
+
+// secretFlags are flags whose values are replaced by redacted in the manifest,
+// so that the manifest can be shared without leaking credentials.
+var secretFlags = map[string]bool{
+	"pass": true,
+}
+
+// redacted is recorded in the manifest in place of values of secretFlags.
+const redacted = "REDACTED"
+
+// Manifest records everything needed to reproduce a run.
+type Manifest struct {
+	Time    time.Time         `json:"time"`
+	Flags   map[string]string `json:"flags"`
+	Env     map[string]string `json:"env"`
+	Runtime map[string]string `json:"runtime"`
+	Modules map[string]string `json:"modules"`
+}
+
+func newManifest() *Manifest {
+	m := &Manifest{
+		Time:  time.Now(),
+		Flags: make(map[string]string),
+		Env:   make(map[string]string),
+		Runtime: map[string]string{
+			"go_version": runtime.Version(),
+			"goos":       runtime.GOOS,
+			"goarch":     runtime.GOARCH,
+			"num_cpu":    fmt.Sprint(runtime.NumCPU()),
+			"gomaxprocs": fmt.Sprint(runtime.GOMAXPROCS(0)),
+		},
+		Modules: make(map[string]string),
+	}
+	visitFlags(flag.CommandLine, m.Flags)
+	for _, key := range manifestEnvs {
+		if v, ok := os.LookupEnv(key); ok {
+			m.Env[key] = v
+		}
+	}
+	if host, err := os.Hostname(); err == nil {
+		m.Runtime["hostname"] = host
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			m.Modules[dep.Path] = dep.Version
+		}
+	}
+	return m
+}
+
+// visitFlags records values of flags of fs to flags, with secrets redacted.
+func visitFlags(fs *flag.FlagSet, flags map[string]string) {
+	fs.VisitAll(func(f *flag.Flag) {
+		// config and manifest are excluded to feed the manifest back via -config,
+		// and ratio and op_mix are excluded since write_percent and ryw_percent
+		// have the resolved values.
+		if f.Name == "config" || f.Name == "manifest" || f.Name == "ratio" || f.Name == "op_mix" {
+			return
+		}
+		flags[f.Name] = redactFlag(f.Name, f.Value.String())
+	})
+}
+
+// flagValues returns values of the flags of c, as resolved for the run.
+func (c *Config) flagValues() map[string]string {
+	var (
+		conf  = *c
+		fs    = flag.NewFlagSet("stats", flag.ContinueOnError)
+		flags = make(map[string]string)
+	)
+	conf.registerFlagSet(fs)
+	visitFlags(fs, flags)
+	return flags
+}
+
+// redactFlag returns redacted for a non-empty value of the secret flag name,
+// and value otherwise.
+func redactFlag(name, value string) string {
+	if secretFlags[name] && value != "" {
+		return redacted
+	}
+	return value
+}
+
+// WriteManifest writes the manifest of the run to the file given by -manifest.
+// it does nothing if -manifest is not set.
+func (s *Stats) WriteManifest() error {
+	if s.Config.Manifest == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(newManifest(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.Config.Manifest, b, 0644)
+}
+
+// loadManifestFlags sets flags recorded in the manifest file, except for the
+// flags which are set on the command line.
+func loadManifestFlags(fs *flag.FlagSet, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(b, m); err != nil {
+		return fmt.Errorf("invalid manifest %s: %v", path, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+		for _, name := range impliedFlags[f.Name] {
+			set[name] = true
+		}
+	})
+	for name, value := range m.Flags {
+		// secrets aren't recorded, so they have to be given on the command line.
+		if set[name] || (secretFlags[name] && value == redacted) {
+			continue
+		}
+		// the manifest records every flag, so flags left with their values
+		// aren't set, or they would all count as given to checkExclusiveFlags.
+		if f := fs.Lookup(name); f != nil && f.Value.String() == value {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid manifest %s: %v", path, err)
+		}
+	}
+	return checkExclusiveFlags(fs)
+}
@@ -0,0 +1,152 @@
+package stats
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withCommandLine runs f with a fresh flag.CommandLine and os.Args of args,
+// and restores them after f.
+func withCommandLine(t *testing.T, args []string, f func()) {
+	t.Helper()
+	savedFlags, savedArgs := flag.CommandLine, os.Args
+	defer func() { flag.CommandLine, os.Args = savedFlags, savedArgs }()
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	flag.CommandLine.SetOutput(ioutil.Discard)
+	os.Args = append([]string{"test"}, args...)
+	f()
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	var (
+		dir  = writeTestFiles(t, nil)
+		path = filepath.Join(dir, "manifest.json")
+	)
+	withCommandLine(t, []string{"-run_for=2s", "-req_count=7", "-seed=3", "-manifest=" + path}, func() {
+		c := NewConfig()
+		c.RegisterFlags()
+		if err := c.ParseFlags(); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewStats(c).WriteManifest(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(b, m); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"run_for": "2s", "req_count": "7", "seed": "3"} {
+		if got := m.Flags[name]; got != want {
+			t.Errorf("manifest flag %s = %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"config", "manifest"} {
+		if _, ok := m.Flags[name]; ok {
+			t.Errorf("manifest has flag %s, which can't be fed back", name)
+		}
+	}
+	if m.Runtime["go_version"] == "" {
+		t.Error("manifest doesn't have the go version")
+	}
+
+	// flags of the manifest are fed back by -config, and flags on the
+	// command line take precedence.
+	withCommandLine(t, []string{"-config=" + path, "-req_count=9"}, func() {
+		c := NewConfig()
+		c.RegisterFlags()
+		if err := c.ParseFlags(); err != nil {
+			t.Fatal(err)
+		}
+		if c.RunFor != 2*time.Second || c.Seed != 3 || c.ReqCount != 9 {
+			t.Errorf("run_for, seed, req_count = %v, %d, %d, want 2s, 3, 9", c.RunFor, c.Seed, c.ReqCount)
+		}
+		if c.Manifest != "" {
+			t.Errorf("manifest = %q, want it not fed back", c.Manifest)
+		}
+	})
+}
+
+func TestManifestRedactsSecrets(t *testing.T) {
+	var (
+		dir  = writeTestFiles(t, nil)
+		path = filepath.Join(dir, "manifest.json")
+		pass string
+	)
+	withCommandLine(t, []string{"-pass=s3cret", "-seed=3", "-manifest=" + path}, func() {
+		flag.StringVar(&pass, "pass", "", "password")
+		c := NewConfig()
+		c.RegisterFlags()
+		if err := c.ParseFlags(); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewStats(c).WriteManifest(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "s3cret") {
+		t.Errorf("manifest has the password:\n%s", b)
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(b, m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Flags["pass"]; got != redacted {
+		t.Errorf("manifest flag pass = %q, want %q", got, redacted)
+	}
+
+	// the placeholder isn't fed back, so the password is given again.
+	withCommandLine(t, []string{"-config=" + path, "-pass=other"}, func() {
+		flag.StringVar(&pass, "pass", "", "password")
+		c := NewConfig()
+		c.RegisterFlags()
+		if err := c.ParseFlags(); err != nil {
+			t.Fatal(err)
+		}
+		if pass != "other" || c.Seed != 3 {
+			t.Errorf("pass, seed = %q, %d, want other, 3", pass, c.Seed)
+		}
+	})
+	withCommandLine(t, []string{"-config=" + path}, func() {
+		flag.StringVar(&pass, "pass", "", "password")
+		c := NewConfig()
+		c.RegisterFlags()
+		if err := c.ParseFlags(); err != nil {
+			t.Fatal(err)
+		}
+		if pass != "" {
+			t.Errorf("pass = %q, want the placeholder not fed back", pass)
+		}
+	})
+}
+
+func TestLoadManifestFlagsInvalid(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"broken.json":  "{",
+		"unknown.json": `{"flags": {"no_such_flag": "1"}}`,
+	})
+	for _, name := range []string{"broken.json", "unknown.json", "missing.json"} {
+		withCommandLine(t, nil, func() {
+			NewConfig().RegisterFlags()
+			if err := loadManifestFlags(flag.CommandLine, filepath.Join(dir, name)); err == nil {
+				t.Errorf("loadManifestFlags(%s) succeeded", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// Summary is a result of a run written to -summary_file, which is merged
+// with summaries of other instances running the same load.
+type Summary struct {
+	Instances int                   `json:"instances"`
+	Ops       map[string]*OpSummary `json:"ops"`
+}
+
+// OpSummary is a result of an op with a sketch of its durations.
+type OpSummary struct {
+	Tries  int     `json:"tries"`
+	Ok     int     `json:"ok"`
+	Sketch *Sketch `json:"sketch"`
+}
+
+// newSummary returns the summary of recorders by op.
+func newSummary(recs map[string]*Recorder) *Summary {
+	sum := &Summary{Instances: 1, Ops: make(map[string]*OpSummary)}
+	for op, rec := range recs {
+		sketch := NewSketch()
+		for _, d := range rec.durations {
+			sketch.Add(d)
+		}
+		sum.Ops[op] = &OpSummary{Tries: rec.Tries, Ok: rec.Ok, Sketch: sketch}
+	}
+	return sum
+}
+
+// writeSummary writes the summary of recorders to -summary_file.
+func (s *Stats) writeSummary(recs map[string]*Recorder) error {
+	b, err := json.Marshal(newSummary(recs))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.Config.SummaryFile, append(b, '\n'), 0644)
+}
+
+// ReadSummary reads a summary written by -summary_file.
+func ReadSummary(path string) (*Summary, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := new(Summary)
+	if err := json.Unmarshal(b, sum); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return sum, nil
+}
+
+// MergeSummaries returns the global summary of summaries of instances.
+func MergeSummaries(sums ...*Summary) *Summary {
+	merged := &Summary{Ops: make(map[string]*OpSummary)}
+	for _, sum := range sums {
+		merged.Instances += sum.Instances
+		for op, o := range sum.Ops {
+			m, ok := merged.Ops[op]
+			if !ok {
+				m = &OpSummary{Sketch: NewSketch()}
+				merged.Ops[op] = m
+			}
+			m.Tries += o.Tries
+			m.Ok += o.Ok
+			if o.Sketch != nil {
+				m.Sketch.Merge(o.Sketch)
+			}
+		}
+	}
+	return merged
+}
+
+func (sum *Summary) String() string {
+	ops := make([]string, 0, len(sum.Ops))
+	for op := range sum.Ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "instances: %d\n", sum.Instances)
+	for _, op := range ops {
+		o := sum.Ops[op]
+		fmt.Fprintf(&buf, "%s (%d ok / %d tries):\n", op, o.Ok, o.Tries)
+		for _, p := range comparePercentiles {
+			fmt.Fprintf(&buf, "  p%v: %v\n", p, time.Duration(o.Sketch.Quantile(p/100)))
+		}
+	}
+	return buf.String()
+}
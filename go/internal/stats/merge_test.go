@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMergeSummariesAccuracy(t *testing.T) {
+	var (
+		r   = rand.New(rand.NewSource(1))
+		all []float64
+		// instances of different latencies, so the merged quantiles differ
+		// from the quantiles of each.
+		scales = []time.Duration{time.Millisecond, 10 * time.Millisecond, 100 * time.Microsecond}
+		sums   []*Summary
+	)
+	for i, scale := range scales {
+		rec := new(Recorder)
+		for j := 0; j < 10000; j++ {
+			d := time.Duration(r.ExpFloat64() * float64(scale))
+			rec.Record(j%10 != 0, d)
+			all = append(all, float64(d))
+		}
+		// summaries are merged from -summary_file, so they're merged as
+		// decoded from JSON.
+		b, err := json.Marshal(newSummary(map[string]*Recorder{"read": rec}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := new(Summary)
+		if err := json.Unmarshal(b, sum); err != nil {
+			t.Fatal(err)
+		}
+		if sum.Ops["read"].Sketch.Count != int64(rec.Tries) {
+			t.Fatalf("instance %d: sketch count = %d, want %d", i, sum.Ops["read"].Sketch.Count, rec.Tries)
+		}
+		sums = append(sums, sum)
+	}
+
+	merged := MergeSummaries(sums...)
+	if merged.Instances != len(scales) {
+		t.Errorf("instances = %d, want %d", merged.Instances, len(scales))
+	}
+	o := merged.Ops["read"]
+	if o.Tries != len(all) || o.Ok != len(all)*9/10 {
+		t.Errorf("ok / tries = %d / %d, want %d / %d", o.Ok, o.Tries, len(all)*9/10, len(all))
+	}
+	sort.Float64s(all)
+	for _, q := range []float64{0, 0.25, 0.5, 0.9, 0.99, 0.999, 1} {
+		var (
+			want = all[int(q*float64(len(all)-1))]
+			got  = o.Sketch.Quantile(q)
+		)
+		if math.Abs(got-want) > want*sketchAccuracy {
+			t.Errorf("quantile %v = %v, want %v within %v", q, time.Duration(got), time.Duration(want), sketchAccuracy)
+		}
+	}
+}
+
+func TestSketchZero(t *testing.T) {
+	s := NewSketch()
+	for i := 0; i < 3; i++ {
+		s.Add(0)
+	}
+	s.Add(float64(time.Millisecond))
+	if got := s.Quantile(0.5); got != 0 {
+		t.Errorf("median = %v, want 0", got)
+	}
+	if got := s.Quantile(1); math.Abs(got-float64(time.Millisecond)) > float64(time.Millisecond)*sketchAccuracy {
+		t.Errorf("max = %v, want 1ms", time.Duration(got))
+	}
+	if got := NewSketch().Quantile(0.5); got != 0 {
+		t.Errorf("median of empty = %v, want 0", got)
+	}
+}
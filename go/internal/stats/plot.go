@@ -0,0 +1,159 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// size of the ASCII plot in characters.
+const (
+	plotWidth  = 60
+	plotHeight = 20
+)
+
+// size of the SVG plot in pixels.
+const (
+	svgWidth  = 640
+	svgHeight = 400
+	svgMargin = 50
+)
+
+// plot logs the latency CDFs of recorders as ASCII plots, and writes SVG
+// plots to files named after the -plot_svg flag with the op if it's set.
+func (s *Stats) plot(recs map[string]*Recorder) error {
+	ops := make([]string, 0, len(recs))
+	for op := range recs {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	for _, op := range ops {
+		sorted := sortedDurations(recs[op].durations)
+		if s.Config.Plot {
+			var b strings.Builder
+			plotASCII(&b, sorted)
+			log.Printf("Latency CDF of %s:\n%s", op, b.String())
+		}
+		if s.Config.PlotSVG != "" {
+			var (
+				ext  = filepath.Ext(s.Config.PlotSVG)
+				base = strings.TrimSuffix(s.Config.PlotSVG, ext)
+			)
+			if err := writeSVGFile(fmt.Sprintf("%s.%s%s", base, op, ext), op, sorted); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// plotASCII writes the CDF of sorted durations as an ASCII plot, with the
+// latency on the x axis and the percentile on the y axis.
+func plotASCII(w io.Writer, sorted []float64) {
+	n := len(sorted)
+	if n == 0 {
+		fmt.Fprintln(w, "(no data)")
+		return
+	}
+	var (
+		min  = sorted[0]
+		max  = sorted[n-1]
+		grid = make([][]byte, plotHeight)
+	)
+	for i := range grid {
+		grid[i] = []byte(strings.Repeat(" ", plotWidth))
+	}
+	for x := 0; x < plotWidth; x++ {
+		// the latency at the right edge of the column.
+		v := min + (max-min)*float64(x+1)/plotWidth
+		count := sort.Search(n, func(i int) bool { return sorted[i] > v })
+		y := (count*plotHeight - 1) / n
+		grid[plotHeight-1-y][x] = '*'
+	}
+
+	for i, row := range grid {
+		label := "     "
+		switch i {
+		case 0:
+			label = "100%"
+		case plotHeight / 2:
+			label = " 50%"
+		case plotHeight - 1:
+			label = "  0%"
+		}
+		fmt.Fprintf(w, "%5s|%s\n", label, row)
+	}
+	fmt.Fprintf(w, "%5s+%s\n", "", strings.Repeat("-", plotWidth))
+	var (
+		left  = time.Duration(min).String()
+		right = time.Duration(max).String()
+	)
+	fmt.Fprintf(w, "%6s%s%*s\n", "", left, plotWidth-utf8.RuneCountInString(left), right)
+	fmt.Fprintf(w, "%6s%*s\n", "", (plotWidth+len("latency"))/2, "latency")
+}
+
+func writeSVGFile(path, title string, sorted []float64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeSVG(f, title, sorted); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeSVG writes the CDF of sorted durations as an SVG plot, using the
+// percentile distribution of HdrHistogram for points of the curve.
+func writeSVG(w io.Writer, title string, sorted []float64) error {
+	var (
+		bw     = bufio.NewWriter(w)
+		points = hgrmPoints(sorted)
+		left   = float64(svgMargin)
+		right  = float64(svgWidth - svgMargin)
+		top    = float64(svgMargin)
+		bottom = float64(svgHeight - svgMargin)
+		min    float64
+		max    float64
+	)
+	if len(sorted) > 0 {
+		min, max = sorted[0], sorted[len(sorted)-1]
+	}
+	x := func(v float64) float64 {
+		if max == min {
+			return left
+		}
+		return left + (right-left)*(v-min)/(max-min)
+	}
+	y := func(percentile float64) float64 {
+		return bottom - (bottom-top)*percentile/100
+	}
+
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`+"\n", svgWidth, svgHeight)
+	fmt.Fprintf(bw, `<text x="%d" y="%d" text-anchor="middle">Latency CDF of %s</text>`+"\n", svgWidth/2, svgMargin/2, title)
+	fmt.Fprintf(bw, `<polyline fill="none" stroke="black" points="%.1f,%.1f %.1f,%.1f %.1f,%.1f"/>`+"\n", left, top, left, bottom, right, bottom)
+	fmt.Fprintf(bw, `<text x="%.1f" y="%.1f" text-anchor="end">100%%</text>`+"\n", left-5, top)
+	fmt.Fprintf(bw, `<text x="%.1f" y="%.1f" text-anchor="end">0%%</text>`+"\n", left-5, bottom)
+	fmt.Fprintf(bw, `<text x="%.1f" y="%.1f">%v</text>`+"\n", left, bottom+20, time.Duration(min))
+	fmt.Fprintf(bw, `<text x="%.1f" y="%.1f" text-anchor="end">%v</text>`+"\n", right, bottom+20, time.Duration(max))
+	fmt.Fprintf(bw, `<text x="%d" y="%.1f" text-anchor="middle">latency</text>`+"\n", svgWidth/2, bottom+40)
+
+	fmt.Fprint(bw, `<polyline fill="none" stroke="steelblue" points="`)
+	for i, p := range points {
+		if i > 0 {
+			fmt.Fprint(bw, " ")
+		}
+		fmt.Fprintf(bw, "%.1f,%.1f", x(p.value), y(p.percentile))
+	}
+	fmt.Fprint(bw, "\"/>\n</svg>\n")
+	return bw.Flush()
+}
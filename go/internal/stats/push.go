@@ -0,0 +1,46 @@
+package stats
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// push pushes the results of the run to the Prometheus Pushgateway.
+func (s *Stats) push(read, write *Recorder) error {
+	var (
+		reg     = prometheus.NewRegistry()
+		latency = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Name:       "loadtest_latency_seconds",
+			Help:       "Latency of operations.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.75: 0.01, 0.95: 0.005, 0.99: 0.001},
+		}, []string{LabelBackend, LabelOp})
+		ops = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "loadtest_ops_total",
+			Help: "Number of operations.",
+		}, []string{LabelBackend, LabelOp, LabelResult})
+	)
+	reg.MustRegister(latency, ops)
+
+	for op, rec := range map[string]*Recorder{"read": read, "write": write} {
+		for _, d := range rec.durations {
+			latency.With(s.pushLabels(op, "")).Observe(time.Duration(d).Seconds())
+		}
+		ops.With(s.pushLabels(op, "ok")).Add(float64(rec.Ok))
+		ops.With(s.pushLabels(op, "error")).Add(float64(rec.Tries - rec.Ok))
+	}
+
+	return push.New(s.Config.Pushgateway, s.Config.PushJob).
+		Gatherer(reg).
+		Grouping(LabelInstance, s.Config.Labels("", "")[LabelInstance]).
+		Push()
+}
+
+// pushLabels returns the labels of metrics of op with result, except the
+// instance which groups the pushed metrics instead.
+func (s *Stats) pushLabels(op, result string) prometheus.Labels {
+	labels := s.Config.Labels(op, result)
+	delete(labels, LabelInstance)
+	return labels
+}
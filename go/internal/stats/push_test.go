@@ -0,0 +1,58 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPush(t *testing.T) {
+	type request struct {
+		method, path string
+		body         []byte
+	}
+	reqs := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		reqs <- request{method: r.Method, path: r.URL.Path, body: body}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	conf := NewConfig()
+	conf.RunFor = 20 * time.Millisecond
+	conf.ReqCount = 1
+	conf.Pushgateway = srv.URL
+	conf.PushJob = "test"
+	op := func(ctx context.Context, id int) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	if _, _, err := NewStats(conf).Start(op, op); err != nil {
+		t.Fatal(err)
+	}
+
+	var req request
+	select {
+	case req = <-reqs:
+	default:
+		t.Fatal("nothing is pushed")
+	}
+	instance, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/metrics/job/test/instance/" + instance; req.method != http.MethodPut || req.path != want {
+		t.Errorf("pushed by %s %s, want PUT %s", req.method, req.path, want)
+	}
+	for _, name := range []string{"loadtest_latency_seconds", "loadtest_ops_total"} {
+		if !bytes.Contains(req.body, []byte(name)) {
+			t.Errorf("pushed metrics don't include %s", name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStartQueueDelay(t *testing.T) {
+	tests := []struct {
+		name string
+		conf func(c *Config)
+	}{
+		{
+			name: "target_qps",
+			conf: func(c *Config) {
+				c.RunFor = 300 * time.Millisecond
+				c.TargetQPS = 500
+			},
+		},
+		{
+			name: "min_run_duration",
+			conf: func(c *Config) {
+				c.TotalOps = 40
+				c.MinRunDuration = 100 * time.Millisecond
+			},
+		},
+	}
+	op := func(ctx context.Context, id int) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	run := func(t *testing.T, reqCount int, conf func(c *Config)) *Recorder {
+		c := NewConfig()
+		c.ReqCount = reqCount
+		c.WritePercent = 50
+		conf(c)
+		s := NewStats(c)
+		read, write, err := s.Start(op, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := read.Tries + write.Tries; s.QueueDelay.Tries != n {
+			t.Errorf("queue delay tries = %d, want %d of all ops", s.QueueDelay.Tries, n)
+		}
+		return s.QueueDelay
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a worker serves 100 ops/s, below the offered load of 400 or
+			// 500 ops/s, while 50 workers serve it without queueing.
+			var (
+				saturated = run(t, 1, tt.conf).Percentile(50)
+				idle      = run(t, 50, tt.conf).Percentile(50)
+			)
+			if saturated < 2*time.Millisecond || saturated <= idle {
+				t.Errorf("median queue delay = %v on 1 worker, %v on 50 workers, want it to grow on 1 worker", saturated, idle)
+			}
+		})
+	}
+
+	t.Run("closed_loop", func(t *testing.T) {
+		d := run(t, 4, func(c *Config) { c.RunFor = 100 * time.Millisecond })
+		if d.Tries == 0 {
+			t.Error("queue delay isn't recorded without pacing")
+		}
+	})
+}
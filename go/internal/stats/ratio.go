@@ -0,0 +1,172 @@
+package stats
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exclusiveFlags are pairs of flags which must not be set together.
+var exclusiveFlags = [][2]string{
+	{"ratio", "write_percent"},
+	{"op_mix", "write_percent"},
+	{"op_mix", "ryw_percent"},
+	{"op_mix", "ratio"},
+	{"runs", "workload_script"},
+}
+
+// impliedFlags are flags set by other flags, which aren't overwritten by the
+// manifest when the other flag is given on the command line.
+var impliedFlags = map[string][]string{
+	"ratio":  {"write_percent"},
+	"op_mix": {"write_percent", "ryw_percent"},
+}
+
+func checkExclusiveFlags(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, pair := range exclusiveFlags {
+		if set[pair[0]] && set[pair[1]] {
+			return &ConfigError{Err: fmt.Errorf("-%s and -%s are mutually exclusive", pair[0], pair[1])}
+		}
+	}
+	return nil
+}
+
+// ratioValue is a flag.Value which sets the write percentage from the
+// write:read ratio form.
+type ratioValue struct {
+	percent *int
+	ratio   string
+}
+
+func (r *ratioValue) String() string {
+	if r == nil {
+		return ""
+	}
+	return r.ratio
+}
+
+func (r *ratioValue) Set(v string) error {
+	percent, err := parseRatio(v)
+	if err != nil {
+		return err
+	}
+	*r.percent = percent
+	r.ratio = v
+	return nil
+}
+
+// parseRatio parses "W:R" and returns the percentage of writes.
+func parseRatio(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid ratio %q; must be W:R", s)
+	}
+	w, werr := strconv.Atoi(parts[0])
+	r, rerr := strconv.Atoi(parts[1])
+	if werr != nil || rerr != nil || w < 0 || r < 0 {
+		return 0, fmt.Errorf("invalid ratio %q; both parts must be non-negative integers", s)
+	}
+	if w+r == 0 {
+		return 0, fmt.Errorf("invalid ratio %q; both parts must not be zero", s)
+	}
+	return int(math.Round(float64(w) * 100 / float64(w+r))), nil
+}
+
+// mixOps are the operations weighted by -op_mix.
+var mixOps = []string{"read", "write", "ryw"}
+
+// opMixValue is a flag.Value which sets the write and read-your-writes
+// percentages from relative weights of operations.
+type opMixValue struct {
+	write *int
+	ryw   *int
+	mix   string
+}
+
+func (o *opMixValue) String() string {
+	if o == nil {
+		return ""
+	}
+	return o.mix
+}
+
+func (o *opMixValue) Set(v string) error {
+	percents, err := parseOpMix(v)
+	if err != nil {
+		return err
+	}
+	*o.write = percents["write"]
+	*o.ryw = percents["ryw"]
+	o.mix = v
+	return nil
+}
+
+// parseOpMix parses "op:weight,..." and returns the percentage of each
+// operation. weights are relative, so they are normalized to sum to 100, and
+// operations not in the mix get 0.
+func parseOpMix(s string) (map[string]int, error) {
+	weights := make(map[string]int)
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.Split(part, ":")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid op mix %q; must be op:weight,...", s)
+		}
+		op := strings.TrimSpace(kv[0])
+		if !containsOp(op) {
+			return nil, fmt.Errorf("invalid op mix %q; op must be one of %s", s, strings.Join(mixOps, ", "))
+		}
+		if _, ok := weights[op]; ok {
+			return nil, fmt.Errorf("invalid op mix %q; %s is given twice", s, op)
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid op mix %q; weights must be non-negative integers", s)
+		}
+		weights[op] = w
+		total += w
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("invalid op mix %q; all weights are zero", s)
+	}
+	return normalizeWeights(weights, total), nil
+}
+
+func containsOp(op string) bool {
+	for _, o := range mixOps {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeWeights returns percentages of weights by the largest remainder
+// method, so they sum to exactly 100.
+func normalizeWeights(weights map[string]int, total int) map[string]int {
+	var (
+		percents = make(map[string]int)
+		sum      = 0
+		ops      = append([]string(nil), mixOps...)
+	)
+	for _, op := range mixOps {
+		percents[op] = weights[op] * 100 / total
+		sum += percents[op]
+	}
+	// ties of remainders go to the op earlier in mixOps.
+	sort.SliceStable(ops, func(i, j int) bool {
+		return weights[ops[i]]*100%total > weights[ops[j]]*100%total
+	})
+	for i := 0; sum < 100; i++ {
+		percents[ops[i]]++
+		sum++
+	}
+	return percents
+}
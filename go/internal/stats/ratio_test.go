@@ -0,0 +1,195 @@
+package stats
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestFlagSet() (*Config, *flag.FlagSet) {
+	c := NewConfig()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	c.registerFlagSet(fs)
+	return c, fs
+}
+
+func writeTestManifest(t *testing.T, flags map[string]string) string {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	b, err := json.Marshal(&Manifest{Flags: flags})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "manifest.json")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseRatio(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "1:9", want: 10},
+		{in: "1:1", want: 50},
+		{in: "0:5", want: 0},
+		{in: "5:0", want: 100},
+		{in: "1:2", want: 33},
+		{in: "2:1", want: 67},
+		{in: "0:0", wantErr: true},
+		{in: "1", wantErr: true},
+		{in: "1:2:3", wantErr: true},
+		{in: "-1:2", wantErr: true},
+		{in: "a:b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRatio(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRatio(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parseRatio(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRatioFlag(t *testing.T) {
+	c, fs := newTestFlagSet()
+	if err := fs.Parse([]string{"-ratio=1:3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkExclusiveFlags(fs); err != nil {
+		t.Fatal(err)
+	}
+	if c.WritePercent != 25 {
+		t.Errorf("WritePercent = %d, want 25", c.WritePercent)
+	}
+}
+
+func TestCheckExclusiveFlags(t *testing.T) {
+	_, fs := newTestFlagSet()
+	if err := fs.Parse([]string{"-ratio=1:3", "-write_percent=10"}); err != nil {
+		t.Fatal(err)
+	}
+	err := checkExclusiveFlags(fs)
+	if _, ok := err.(*ConfigError); !ok {
+		t.Errorf("checkExclusiveFlags() = %v, want *ConfigError", err)
+	}
+}
+
+func TestLoadManifestFlagsKeepsRatio(t *testing.T) {
+	path := writeTestManifest(t, map[string]string{"write_percent": "80", "req_count": "7"})
+	c, fs := newTestFlagSet()
+	if err := fs.Parse([]string{"-ratio=1:3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadManifestFlags(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if c.WritePercent != 25 {
+		t.Errorf("WritePercent = %d, want 25 from -ratio", c.WritePercent)
+	}
+	if c.ReqCount != 7 {
+		t.Errorf("ReqCount = %d, want 7 from the manifest", c.ReqCount)
+	}
+}
+
+func TestLoadManifestFlagsChecksExclusive(t *testing.T) {
+	path := writeTestManifest(t, map[string]string{"runs": "a", "workload_script": "b"})
+	_, fs := newTestFlagSet()
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	err := loadManifestFlags(fs, path)
+	if _, ok := err.(*ConfigError); !ok {
+		t.Errorf("loadManifestFlags() = %v, want *ConfigError", err)
+	}
+}
+
+func TestParseOpMix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]int
+	}{
+		{in: "read:90,write:10", want: map[string]int{"read": 90, "write": 10, "ryw": 0}},
+		{in: "read:9,write:1", want: map[string]int{"read": 90, "write": 10, "ryw": 0}},
+		{in: "read:1,write:1,ryw:1", want: map[string]int{"read": 34, "write": 33, "ryw": 33}},
+		{in: "write:2,ryw:1", want: map[string]int{"read": 0, "write": 67, "ryw": 33}},
+		{in: " read : 3 , ryw : 1 ", want: map[string]int{"read": 75, "write": 0, "ryw": 25}},
+	}
+	for _, tt := range tests {
+		got, err := parseOpMix(tt.in)
+		if err != nil {
+			t.Errorf("parseOpMix(%q) error = %v", tt.in, err)
+			continue
+		}
+		sum := 0
+		for op, want := range tt.want {
+			if got[op] != want {
+				t.Errorf("parseOpMix(%q)[%s] = %d, want %d", tt.in, op, got[op], want)
+			}
+			sum += got[op]
+		}
+		if sum != 100 {
+			t.Errorf("parseOpMix(%q) sums to %d, want 100", tt.in, sum)
+		}
+	}
+}
+
+func TestParseOpMixInvalid(t *testing.T) {
+	for _, in := range []string{"read:0,write:0", "read:0", "read:1,read:2", "scan:1", "read:-1", "read", "read:1:2", ""} {
+		if got, err := parseOpMix(in); err == nil {
+			t.Errorf("parseOpMix(%q) = %v, want error", in, got)
+		}
+	}
+}
+
+func TestOpMixFlag(t *testing.T) {
+	c, fs := newTestFlagSet()
+	if err := fs.Parse([]string{"-op_mix=read:2,write:1,ryw:1"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.WritePercent != 25 || c.RYWPercent != 25 {
+		t.Errorf("WritePercent, RYWPercent = %d, %d, want 25, 25", c.WritePercent, c.RYWPercent)
+	}
+	if err := fs.Parse([]string{"-op_mix=read:0,write:0"}); err == nil {
+		t.Error("Parse(-op_mix=read:0,write:0) = nil, want error")
+	}
+}
+
+func TestCheckExclusiveFlagsOpMix(t *testing.T) {
+	for _, other := range []string{"-write_percent=10", "-ryw_percent=10", "-ratio=1:1"} {
+		_, fs := newTestFlagSet()
+		if err := fs.Parse([]string{"-op_mix=read:1", other}); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkExclusiveFlags(fs); err == nil {
+			t.Errorf("checkExclusiveFlags() with -op_mix and %s = nil, want error", other)
+		}
+	}
+}
+
+func TestLoadManifestFlagsKeepsOpMix(t *testing.T) {
+	path := writeTestManifest(t, map[string]string{"write_percent": "80", "ryw_percent": "10"})
+	c, fs := newTestFlagSet()
+	if err := fs.Parse([]string{"-op_mix=read:3,ryw:1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadManifestFlags(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if c.WritePercent != 0 || c.RYWPercent != 25 {
+		t.Errorf("WritePercent, RYWPercent = %d, %d, want 0, 25 from -op_mix", c.WritePercent, c.RYWPercent)
+	}
+}
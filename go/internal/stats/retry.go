@@ -0,0 +1,40 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// maxBackoffShift caps the exponential growth of the retry backoff.
+const maxBackoffShift = 10
+
+// call calls f, and retries it up to -client_retries times while it fails
+// and ctx is not done. a skipped call isn't retried. it returns the number of
+// calls and the error of the last call.
+func (s *Stats) call(ctx context.Context, f StatsFunc, id int) (attempts int, err error) {
+	for attempts = 1; ; attempts++ {
+		if err = f(ctx, id); err == nil || errors.Is(err, ErrSkipped) || attempts > s.Config.ClientRetries {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(fullJitter(s.Config.RetryBackoff, attempts)):
+		}
+	}
+}
+
+// fullJitter returns a random backoff between 0 and base*2^(attempt-1).
+func fullJitter(base time.Duration, attempt int) time.Duration {
+	shift := attempt - 1
+	if shift > maxBackoffShift {
+		shift = maxBackoffShift
+	}
+	max := int64(base) << uint(shift)
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(max))
+}
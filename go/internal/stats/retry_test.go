@@ -0,0 +1,76 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientRetriesAmplification(t *testing.T) {
+	const (
+		failRate = 0.3
+		retries  = 3
+	)
+	conf := NewConfig()
+	conf.RunFor = 200 * time.Millisecond
+	conf.ReqCount = 4
+	conf.ClientRetries = retries
+	conf.RetryBackoff = 0
+	var (
+		mu  sync.Mutex
+		rnd = rand.New(rand.NewSource(1))
+		op  = func(ctx context.Context, id int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if rnd.Float64() < failRate {
+				return errors.New("unavailable")
+			}
+			return nil
+		}
+	)
+	read, write, err := NewStats(conf).Start(op, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an operation calls the backend once, and once more for each failure
+	// up to the retries.
+	var (
+		wantAmp = 1 + failRate + math.Pow(failRate, 2) + math.Pow(failRate, 3)
+		wantOk  = 1 - math.Pow(failRate, retries+1)
+	)
+	for name, rec := range map[string]*Recorder{"read": &read, "write": &write} {
+		if rec.Tries < 1000 {
+			t.Fatalf("%s tries = %d, want more to measure", name, rec.Tries)
+		}
+		if amp := rec.Amplification(); math.Abs(amp-wantAmp) > 0.05 {
+			t.Errorf("%s amplification = %.3f, want %.3f", name, amp, wantAmp)
+		}
+		if ok := float64(rec.Ok) / float64(rec.Tries); math.Abs(ok-wantOk) > 0.01 {
+			t.Errorf("%s success rate = %.4f, want %.4f", name, ok, wantOk)
+		}
+	}
+}
+
+func TestFullJitter(t *testing.T) {
+	const base = 10 * time.Millisecond
+	for attempt := 1; attempt <= maxBackoffShift+3; attempt++ {
+		shift := attempt - 1
+		if shift > maxBackoffShift {
+			shift = maxBackoffShift
+		}
+		max := base << uint(shift)
+		for i := 0; i < 100; i++ {
+			if d := fullJitter(base, attempt); d < 0 || d >= max {
+				t.Fatalf("fullJitter(%v, %d) = %v, want [0, %v)", base, attempt, d, max)
+			}
+		}
+	}
+	if d := fullJitter(0, 1); d != 0 {
+		t.Errorf("fullJitter(0, 1) = %v, want 0", d)
+	}
+}
@@ -0,0 +1,299 @@
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Run is a result of a named run.
+type Run struct {
+	Name  string
+	Read  *Recorder
+	Write *Recorder
+
+	// rounds are results of each round if the run was interleaved.
+	rounds []round
+}
+
+type namedConfig struct {
+	name string
+	conf *Config
+}
+
+// parseRuns parses runs flag value formatted as name1=flagsfile1,name2=flagsfile2.
+// each config is copied from base and overridden by flags written in the file.
+func parseRuns(base Config, runs string) ([]namedConfig, error) {
+	var confs []namedConfig
+	for _, run := range strings.Split(runs, ",") {
+		kv := strings.SplitN(strings.TrimSpace(run), "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid run %q; must be name=flagsfile", run)
+		}
+		conf, err := loadFlagsFile(base, kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("run %s: %v", kv[0], err)
+		}
+		confs = append(confs, namedConfig{name: kv[0], conf: conf})
+	}
+	return confs, nil
+}
+
+// parseWorkloadScript parses the workload script. each line of the script is
+// a phase written in flags such as "-run_for=1m -write_percent=90 -target_qps=500",
+// which overrides a copy of base.
+func parseWorkloadScript(base Config, path string) ([]namedConfig, error) {
+	lines, err := readFlagsLines(path)
+	if err != nil {
+		return nil, err
+	}
+	var confs []namedConfig
+	for i, line := range lines {
+		name := fmt.Sprintf("phase%d", i+1)
+		conf, err := applyFlags(base, name, strings.Fields(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		confs = append(confs, namedConfig{name: name, conf: conf})
+	}
+	if len(confs) == 0 {
+		return nil, fmt.Errorf("workload script %s has no phases", path)
+	}
+	return confs, nil
+}
+
+// loadFlagsFile reads flags from file and applies them to a copy of base.
+func loadFlagsFile(base Config, path string) (*Config, error) {
+	lines, err := readFlagsLines(path)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for _, line := range lines {
+		args = append(args, strings.Fields(line)...)
+	}
+	return applyFlags(base, path, args)
+}
+
+// readFlagsLines returns lines of the file except for empty lines and lines
+// starting with '#'.
+func readFlagsLines(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+func applyFlags(base Config, name string, args []string) (*Config, error) {
+	conf := base
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	conf.registerFlagSet(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := checkExclusiveFlags(fs); err != nil {
+		return nil, err
+	}
+	conf.Runs = ""
+	conf.WorkloadScript = ""
+	return &conf, nil
+}
+
+// StartRuns executes each named run of Config.Runs, or each phase of
+// Config.WorkloadScript sequentially. -exit_summary is written once for all
+// the runs, and the other files written by a run are suffixed by its name.
+// SIGINT or SIGTERM stops the run in progress and skips the rest.
+func (s *Stats) StartRuns(readFunc, writeFunc StatsFunc) ([]Run, error) {
+	var (
+		confs []namedConfig
+		err   error
+	)
+	if s.Config.WorkloadScript != "" {
+		confs, err = parseWorkloadScript(*s.Config, s.Config.WorkloadScript)
+	} else {
+		confs, err = parseRuns(*s.Config, s.Config.Runs)
+	}
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	for _, c := range confs {
+		if err := c.conf.Validate(); err != nil {
+			return nil, fmt.Errorf("run %s: %w", c.name, err)
+		}
+	}
+
+	// a signal stops the whole series, so the handler is shared by the runs
+	// instead of installed by each run.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	s.signals = stop
+
+	var (
+		runs []Run
+		sums []exitSummary
+	)
+	if s.Config.Runs != "" && s.Config.Interleave > 1 {
+		runs, sums, err = s.startInterleaved(confs, readFunc, writeFunc)
+	} else {
+		for _, c := range confs {
+			log.Printf("Start run %s", c.name)
+			var r *runResult
+			if r, err = s.startRun(c.conf, c.name, readFunc, writeFunc); r != nil {
+				sums = append(sums, r.sum)
+			}
+			if err != nil {
+				err = fmt.Errorf("run %s: %w", c.name, err)
+				break
+			}
+			runs = append(runs, Run{Name: c.name, Read: r.read, Write: r.write})
+			if r.signaled {
+				log.Printf("Skipping the rest of runs by the signal")
+				break
+			}
+		}
+	}
+	if s.Config.ExitSummary != "" && len(sums) > 0 {
+		if err := s.writeExitSummary(mergeExitSummaries(sums)); err != nil {
+			log.Printf("Error writing exit summary: %v", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// runResult is a result of a run started by startRun.
+type runResult struct {
+	read  *Recorder
+	write *Recorder
+	sum   exitSummary
+	// signaled is whether the run is stopped by a signal.
+	signaled bool
+}
+
+// startRun starts a run of conf with the hooks of s. the files written by
+// the run are suffixed by name, and -exit_summary is left to the caller. the
+// result is returned with an error if the run is aborted, so that it counts
+// toward the exit summary.
+func (s *Stats) startRun(conf *Config, name string, readFunc, writeFunc StatsFunc) (*runResult, error) {
+	c := *conf
+	c.ExitSummary = ""
+	suffixRunFiles(&c, name)
+
+	sts := NewStats(&c)
+	sts.Heartbeat = s.Heartbeat
+	sts.Fatal = s.Fatal
+	sts.Throttled = s.Throttled
+	sts.Validate = s.Validate
+	sts.Sweep = s.Sweep
+	sts.AppendHistory = s.AppendHistory
+	sts.signals = s.signals
+	read, write, err := sts.Start(readFunc, writeFunc)
+	var abort *AbortError
+	if err != nil && !errors.As(err, &abort) {
+		return nil, err
+	}
+	return &runResult{
+		read:     &read,
+		write:    &write,
+		sum:      sts.newExitSummary(&read, &write, err != nil),
+		signaled: sts.signaled,
+	}, err
+}
+
+// suffixRunFiles suffixes the files written by a run of conf by name, such as
+// "out.baseline.json" for "out.json", so runs don't overwrite each other.
+func suffixRunFiles(conf *Config, name string) {
+	for _, path := range []*string{
+		&conf.Trace,
+		&conf.HgrmFile,
+		&conf.PlotSVG,
+		&conf.EventsFile,
+		&conf.FoldedStacks,
+		&conf.Binlog,
+		&conf.SummaryFile,
+	} {
+		if *path == "" {
+			continue
+		}
+		ext := filepath.Ext(*path)
+		*path = fmt.Sprintf("%s.%s%s", strings.TrimSuffix(*path, ext), name, ext)
+	}
+}
+
+var comparePercentiles = []float64{50, 75, 95, 99}
+
+// Compare returns a table comparing runs. the first run is the baseline and
+// the others are printed with the difference from it.
+func Compare(runs []Run) string {
+	if len(runs) == 0 {
+		return ""
+	}
+	var (
+		buf bytes.Buffer
+		w   = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	)
+	fmt.Fprint(w, "op\tmetric")
+	for _, run := range runs {
+		fmt.Fprintf(w, "\t%s", run.Name)
+	}
+	fmt.Fprintln(w)
+
+	for _, op := range []struct {
+		name string
+		rec  func(Run) *Recorder
+	}{
+		{name: "read", rec: func(r Run) *Recorder { return r.Read }},
+		{name: "write", rec: func(r Run) *Recorder { return r.Write }},
+	} {
+		fmt.Fprintf(w, "%s\tok/tries", op.name)
+		for _, run := range runs {
+			rec := op.rec(run)
+			fmt.Fprintf(w, "\t%d/%d", rec.Ok, rec.Tries)
+		}
+		fmt.Fprintln(w)
+
+		for _, p := range comparePercentiles {
+			fmt.Fprintf(w, "%s\tp%v", op.name, p)
+			base := op.rec(runs[0]).Percentile(p)
+			for i, run := range runs {
+				d := op.rec(run).Percentile(p)
+				if i == 0 {
+					fmt.Fprintf(w, "\t%v", d)
+				} else {
+					fmt.Fprintf(w, "\t%v (%s)", d, diff(base, d))
+				}
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func diff(base, d time.Duration) string {
+	if base == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", float64(d-base)/float64(base)*100)
+}
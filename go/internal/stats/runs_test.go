@@ -0,0 +1,255 @@
+package stats
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeTestFiles writes files of contents by name to a temporary directory,
+// and returns the directory.
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestStartRuns(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"baseline.flags":   "# the baseline\n-run_for=50ms -req_count=1\n",
+		"experiment.flags": "-run_for=50ms\n-req_count=4\n",
+	})
+	conf := NewConfig()
+	conf.Runs = "baseline=" + filepath.Join(dir, "baseline.flags") + ",experiment=" + filepath.Join(dir, "experiment.flags")
+
+	var calls int64
+	op := func(ctx context.Context, id int) error {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	runs, err := NewStats(conf).StartRuns(op, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Name != "baseline" || runs[1].Name != "experiment" {
+		t.Fatalf("runs = %+v, want baseline and experiment", runs)
+	}
+	for _, run := range runs {
+		if n := run.Read.Tries + run.Write.Tries; n == 0 {
+			t.Errorf("run %s has no ops", run.Name)
+		}
+	}
+	if n := atomic.LoadInt64(&calls); n != int64(runs[0].Read.Tries+runs[0].Write.Tries+runs[1].Read.Tries+runs[1].Write.Tries) {
+		t.Errorf("calls = %d, want the sum of tries of runs", n)
+	}
+
+	cmp := Compare(runs)
+	header := strings.SplitN(cmp, "\n", 2)[0]
+	if !strings.Contains(header, "baseline") || !strings.Contains(header, "experiment") {
+		t.Errorf("header of comparison = %q, want both labels", header)
+	}
+	for _, metric := range []string{"ok/tries", "p50", "p99"} {
+		if !strings.Contains(cmp, metric) {
+			t.Errorf("comparison doesn't have %s:\n%s", metric, cmp)
+		}
+	}
+}
+
+func TestStartRunsFiles(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"baseline.flags":   "-run_for=50ms",
+		"experiment.flags": "-run_for=50ms -chaos_error_rate=1",
+	})
+	conf := NewConfig()
+	conf.ReqCount = 2
+	conf.Runs = "baseline=" + filepath.Join(dir, "baseline.flags") + ",experiment=" + filepath.Join(dir, "experiment.flags")
+	conf.ExitSummary = filepath.Join(dir, "summary.json")
+	conf.HgrmFile = filepath.Join(dir, "out.hgrm")
+	conf.EventsFile = filepath.Join(dir, "events.json")
+
+	op := func(ctx context.Context, id int) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	runs, err := NewStats(conf).StartRuns(op, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the experiment fails every op, so the runs aren't passed.
+	sum := readExitSummary(t, conf.ExitSummary)
+	var total, ok int
+	for _, run := range runs {
+		total += run.Read.Tries + run.Write.Tries
+		ok += run.Read.Ok + run.Write.Ok
+	}
+	if sum.Passed {
+		t.Error("exit summary is passed, want failed by the experiment")
+	}
+	if sum.TotalOps != total || total == 0 {
+		t.Errorf("total_ops = %d, want %d of both runs", sum.TotalOps, total)
+	}
+	if want := float64(total-ok) / float64(total); sum.ErrorRate != want || ok == 0 {
+		t.Errorf("error_rate = %v, want %v of both runs", sum.ErrorRate, want)
+	}
+	if want := runs[0].Read.Percentile(99).Seconds(); sum.ReadP99 < want {
+		t.Errorf("read_p99 = %v, want at least %v of the baseline", sum.ReadP99, want)
+	}
+
+	for _, name := range []string{
+		"out.baseline.read.hgrm", "out.experiment.read.hgrm",
+		"events.baseline.json", "events.experiment.json",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s isn't written: %v", name, err)
+		}
+	}
+	for _, name := range []string{"out.read.hgrm", "events.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s is written, want it suffixed by runs", name)
+		}
+	}
+}
+
+func TestStartRunsExitSummaryPassed(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"a.flags": "-run_for=30ms",
+		"b.flags": "-run_for=30ms",
+	})
+	conf := NewConfig()
+	conf.ReqCount = 1
+	conf.Runs = "a=" + filepath.Join(dir, "a.flags") + ",b=" + filepath.Join(dir, "b.flags")
+	conf.Interleave = 2
+	conf.ExitSummary = filepath.Join(dir, "summary.json")
+	conf.HgrmFile = filepath.Join(dir, "out.hgrm")
+
+	op := func(ctx context.Context, id int) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	runs, err := NewStats(conf).StartRuns(op, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := readExitSummary(t, conf.ExitSummary)
+	if want := runs[0].Read.Tries + runs[0].Write.Tries + runs[1].Read.Tries + runs[1].Write.Tries; !sum.Passed || sum.TotalOps != want {
+		t.Errorf("passed, total_ops = %v, %d, want true, %d", sum.Passed, sum.TotalOps, want)
+	}
+	for _, name := range []string{"out.a.round1.read.hgrm", "out.b.round2.read.hgrm"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s isn't written: %v", name, err)
+		}
+	}
+}
+
+func TestStartRunsSignal(t *testing.T) {
+	for _, interleave := range []int{0, 2} {
+		dir := writeTestFiles(t, map[string]string{
+			"a.flags": "-run_for=20s",
+			"b.flags": "-run_for=20s",
+		})
+		conf := NewConfig()
+		conf.ReqCount = 1
+		conf.Runs = "a=" + filepath.Join(dir, "a.flags") + ",b=" + filepath.Join(dir, "b.flags")
+		conf.Interleave = interleave
+
+		var (
+			once  sync.Once
+			calls int64
+		)
+		op := func(ctx context.Context, id int) error {
+			if atomic.AddInt64(&calls, 1) == 10 {
+				once.Do(func() {
+					p, err := os.FindProcess(os.Getpid())
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					p.Signal(os.Interrupt)
+				})
+			}
+			time.Sleep(time.Millisecond)
+			return nil
+		}
+		start := time.Now()
+		runs, err := NewStats(conf).StartRuns(op, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("interleave %d: runs took %v, want stopped by the signal", interleave, d)
+		}
+		if len(runs) != 1 || runs[0].Name != "a" {
+			t.Errorf("interleave %d: runs = %+v, want only a", interleave, runs)
+		}
+	}
+}
+
+func TestWorkloadScript(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"script": "# ingest, then serve\n-run_for=50ms -write_percent=100\n\n-run_for=50ms -write_percent=0 -target_qps=200\n",
+	})
+	conf := NewConfig()
+	conf.ReqCount = 2
+	conf.WorkloadScript = filepath.Join(dir, "script")
+
+	var (
+		mu   sync.Mutex
+		ops  []string
+		opOf = func(name string) StatsFunc {
+			return func(ctx context.Context, id int) error {
+				mu.Lock()
+				ops = append(ops, name)
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				return nil
+			}
+		}
+	)
+	runs, err := NewStats(conf).StartRuns(opOf("read"), opOf("write"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Name != "phase1" || runs[1].Name != "phase2" {
+		t.Fatalf("runs = %+v, want phase1 and phase2", runs)
+	}
+	if runs[0].Write.Tries == 0 || runs[0].Read.Tries != 0 {
+		t.Errorf("phase1 has %d reads and %d writes, want writes only", runs[0].Read.Tries, runs[0].Write.Tries)
+	}
+	if runs[1].Read.Tries == 0 || runs[1].Write.Tries != 0 {
+		t.Errorf("phase2 has %d reads and %d writes, want reads only", runs[1].Read.Tries, runs[1].Write.Tries)
+	}
+	// 200 qps for 50ms allows about 10 reads.
+	if n := runs[1].Read.Tries; n > 15 {
+		t.Errorf("phase2 has %d reads, want about 10 by -target_qps", n)
+	}
+	for i := 1; i < len(ops); i++ {
+		if ops[i-1] == "read" && ops[i] == "write" {
+			t.Fatalf("a write runs after a read, want phases in order")
+		}
+	}
+}
+
+func TestParseRunsInvalid(t *testing.T) {
+	for _, runs := range []string{"baseline", "=file", "baseline=", "baseline=/not/exist"} {
+		if _, err := parseRuns(*NewConfig(), runs); err == nil {
+			t.Errorf("parseRuns(%q) succeeded", runs)
+		}
+	}
+}
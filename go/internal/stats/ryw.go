@@ -0,0 +1,32 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RYWStats are results of read-your-writes operations, which write a key and
+// then read it back.
+type RYWStats struct {
+	// Combined is latency of the write and the read.
+	Combined Recorder
+	// Read is latency of the read following the write.
+	Read Recorder
+}
+
+// readYourWrite writes id and then reads it back, recording the read leg to
+// s.RYW. it returns the number of calls and the error of the failed leg.
+func (s *Stats) readYourWrite(ctx context.Context, readFunc, writeFunc StatsFunc, id int) (attempts int, err error) {
+	if attempts, err = s.call(ctx, writeFunc, id); err != nil {
+		return
+	}
+	start, excluded := time.Now(), excludedIn(ctx)
+	n, err := s.call(ctx, readFunc, id)
+	if errors.Is(err, ErrSkipped) {
+		return attempts + n, err
+	}
+	s.RYW.Read.recordAt(err == nil, start, time.Since(start)-(excludedIn(ctx)-excluded))
+	s.RYW.Read.addAttempts(n)
+	return attempts + n, err
+}
@@ -0,0 +1,53 @@
+package stats
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadYourWrites(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	conf := NewConfig()
+	conf.RunFor = 200 * time.Millisecond
+	conf.ReqCount = 2
+	conf.WritePercent = 0
+	conf.RYWPercent = 100
+	// the backend makes a write visible to reads only after the delay, and a
+	// read waits until the key is visible.
+	var (
+		mu      sync.Mutex
+		visible = make(map[int]time.Time)
+		write   = func(ctx context.Context, id int) error {
+			mu.Lock()
+			visible[id] = time.Now().Add(delay)
+			mu.Unlock()
+			return nil
+		}
+		read = func(ctx context.Context, id int) error {
+			mu.Lock()
+			at := visible[id]
+			mu.Unlock()
+			time.Sleep(time.Until(at))
+			return nil
+		}
+	)
+	s := NewStats(conf)
+	if _, _, err := s.Start(read, write); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.RYW == nil || s.RYW.Read.Tries == 0 {
+		t.Fatalf("read-your-writes = %+v, want reads recorded", s.RYW)
+	}
+	if p50 := s.RYW.Read.Percentile(50); p50 < delay || p50 > 2*delay {
+		t.Errorf("read leg p50 = %v, want about %v", p50, delay)
+	}
+	if s.RYW.Combined.Tries != s.RYW.Read.Tries {
+		t.Errorf("%d combined / %d read legs, want the same", s.RYW.Combined.Tries, s.RYW.Read.Tries)
+	}
+	if c, r := s.RYW.Combined.Percentile(50), s.RYW.Read.Percentile(50); c < r {
+		t.Errorf("combined p50 = %v, want at least the read leg p50 %v", c, r)
+	}
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// sketchAccuracy is the relative accuracy of quantiles of a Sketch.
+const sketchAccuracy = 0.01
+
+var sketchGamma = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+
+// Sketch is a streaming quantile sketch of durations in logarithmic buckets,
+// whose quantiles are within sketchAccuracy of the exact ones relative to the
+// value. sketches are merged by adding counts of buckets, so quantiles of
+// durations recorded by multiple instances are merged without raw samples.
+type Sketch struct {
+	// Counts are counts of durations by bucket index.
+	Counts map[int]int64 `json:"counts"`
+	// Zero is the count of durations of 0.
+	Zero  int64 `json:"zero"`
+	Count int64 `json:"count"`
+}
+
+func NewSketch() *Sketch {
+	return &Sketch{Counts: make(map[int]int64)}
+}
+
+// Add adds a duration in nanoseconds.
+func (s *Sketch) Add(v float64) {
+	s.Count++
+	if v <= 0 {
+		s.Zero++
+		return
+	}
+	s.Counts[int(math.Ceil(math.Log(v)/math.Log(sketchGamma)))]++
+}
+
+// Merge adds durations of o.
+func (s *Sketch) Merge(o *Sketch) {
+	for i, n := range o.Counts {
+		s.Counts[i] += n
+	}
+	s.Zero += o.Zero
+	s.Count += o.Count
+}
+
+// Quantile returns the q-th quantile of durations for q in [0, 1].
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.Count-1))
+	if rank < s.Zero {
+		return 0
+	}
+	indices := make([]int, 0, len(s.Counts))
+	for i := range s.Counts {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	seen := s.Zero
+	for _, i := range indices {
+		if seen += s.Counts[i]; seen > rank {
+			// the middle of the bucket in the relative error.
+			return 2 * math.Pow(sketchGamma, float64(i)) / (sketchGamma + 1)
+		}
+	}
+	return 2 * math.Pow(sketchGamma, float64(indices[len(indices)-1])) / (sketchGamma + 1)
+}
@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartSkipped(t *testing.T) {
+	conf := NewConfig()
+	conf.RunFor = 200 * time.Millisecond
+	conf.ReqCount = 2
+	conf.WritePercent = 50
+	conf.ClientRetries = 3
+	conf.RetryBackoff = time.Millisecond
+	var (
+		reads  int64
+		s      = NewStats(conf)
+		sleepy = func(ctx context.Context, id int) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		}
+	)
+	read, write, err := s.Start(func(ctx context.Context, id int) error {
+		atomic.AddInt64(&reads, 1)
+		time.Sleep(time.Millisecond)
+		return ErrSkipped
+	}, sleepy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Tries != 0 {
+		t.Errorf("read tries = %d, want 0 for skipped reads", read.Tries)
+	}
+	if write.Tries == 0 || write.Ok != write.Tries {
+		t.Errorf("write ok / tries = %d / %d, want all writes ok", write.Ok, write.Tries)
+	}
+	// skipped reads aren't retried, so every call is counted once.
+	if n := atomic.LoadInt64(&reads); n == 0 || s.skipped != n {
+		t.Errorf("skipped = %d, reads = %d, want equal and non zero", s.skipped, n)
+	}
+}
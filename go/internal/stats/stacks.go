@@ -0,0 +1,108 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stackSampler samples stacks of all goroutines periodically, and counts
+// them in the folded format of flamegraph tools, where frames from the root
+// are joined by ';'. goroutines are sampled regardless of running or blocked,
+// so the result is a wall-clock profile of the harness.
+type stackSampler struct {
+	counts map[string]int
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func startStackSampler(interval time.Duration) *stackSampler {
+	s := &stackSampler{
+		counts: make(map[string]int),
+		done:   make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.sample()
+			}
+		}
+	}()
+	return s
+}
+
+func (s *stackSampler) sample() {
+	var (
+		records []runtime.StackRecord
+		n, ok   = runtime.GoroutineProfile(nil)
+	)
+	for !ok {
+		// leave room for goroutines started in between.
+		records = make([]runtime.StackRecord, n+10)
+		n, ok = runtime.GoroutineProfile(records)
+	}
+	for _, r := range records[:n] {
+		if stack := foldStack(r.Stack()); stack != "" {
+			s.counts[stack]++
+		}
+	}
+}
+
+// foldStack returns function names of the stack from the root joined by ';'.
+func foldStack(pcs []uintptr) string {
+	var (
+		names  []string
+		frames = runtime.CallersFrames(pcs)
+	)
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" {
+			names = append(names, frame.Function)
+		}
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return strings.Join(names, ";")
+}
+
+// stop stops sampling and writes the folded stacks to path.
+func (s *stackSampler) stop(path string) error {
+	close(s.done)
+	s.wg.Wait()
+
+	stacks := make([]string, 0, len(s.counts))
+	for stack := range s.counts {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, stack := range stacks {
+		fmt.Fprintf(w, "%s %d\n", stack, s.counts[stack])
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
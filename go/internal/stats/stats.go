@@ -2,12 +2,16 @@ package stats
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
+	"os/signal"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/montanaflynn/stats"
@@ -20,109 +24,1237 @@ var allStats int64
 type Config struct {
 	RunFor   time.Duration `validate:"required"`
 	ReqCount int           `validate:"required"`
+	Runs     string
+	Seed     int64
+	Config   string
+	Manifest string
+	Trace    string
+
+	WritePercent int           `validate:"min=0,max=100"`
+	ThinkTime    time.Duration `validate:"min=0"`
+	ThinkJitter  time.Duration `validate:"min=0"`
+	GCStats      bool
+	Pushgateway  string
+	PushJob      string `validate:"required"`
+	WritesPerKey int    `validate:"min=0"`
+	HealthAddr   string
+
+	Backend         string
+	MetricsInstance string
+
+	TargetQPS      int `validate:"min=0"`
+	WorkloadScript string
+	HgrmFile       string
+
+	ClientRetries int           `validate:"min=0"`
+	RetryBackoff  time.Duration `validate:"min=0"`
+
+	Plot    bool
+	PlotSVG string
+
+	PerWorker     bool
+	OutlierFactor float64 `validate:"gt=1"`
+
+	EventsFile string
+	SampleSize int `validate:"min=0"`
+
+	FailFast bool
+
+	RYWPercent int `validate:"min=0,max=100"`
+
+	Interleave int `validate:"min=0"`
+
+	DeadlineBudget time.Duration `validate:"min=0"`
+
+	ExitSummary  string
+	MaxErrorRate float64 `validate:"min=0,max=1"`
+
+	Keys      int `validate:"min=1"`
+	FinalKeys int `validate:"min=0"`
+
+	TimelineClock string `validate:"oneof=wall monotonic"`
+
+	HistoryDB string
+
+	KeyAffinity bool
+
+	ThrottleBackoff bool
+
+	FoldedStacks  string
+	StackInterval time.Duration `validate:"gt=0"`
+
+	Binlog string
+
+	DrainTimeout time.Duration `validate:"min=0"`
+
+	Bootstrap int `validate:"min=0"`
+
+	Allocs bool
+
+	SteadyState     bool
+	SteadyWindow    time.Duration `validate:"gt=0"`
+	SteadyTolerance float64       `validate:"gt=0"`
+
+	ChaosErrorRate   float64       `validate:"min=0,max=1"`
+	ChaosLatency     time.Duration `validate:"min=0"`
+	ChaosLatencyRate float64       `validate:"min=0,max=1"`
+
+	StopAfterErrors int `validate:"min=0"`
+
+	Autocorrelation bool
+
+	TotalOps       int           `validate:"min=0"`
+	MinRunDuration time.Duration `validate:"min=0"`
+
+	WriteDelay time.Duration `validate:"min=0"`
+
+	SummaryFile string
+
+	Estimate bool
+
+	Utilization bool
+
+	UniqueKeys bool
+
+	ExcludeFailedLatency bool
+
+	IntegritySweep bool
 }
 
 func NewConfig() *Config {
-	return new(Config)
+	return &Config{
+		RunFor:           5 * time.Second,
+		ReqCount:         100,
+		WritePercent:     50,
+		PushJob:          "loadtest",
+		RetryBackoff:     100 * time.Millisecond,
+		OutlierFactor:    2,
+		SampleSize:       10000,
+		MaxErrorRate:     0.01,
+		Keys:             100,
+		TimelineClock:    "wall",
+		StackInterval:    50 * time.Millisecond,
+		SteadyWindow:     time.Second,
+		SteadyTolerance:  0.1,
+		ChaosLatencyRate: 1,
+	}
 }
 
 func (c *Config) RegisterFlags() {
-	flag.DurationVar(
+	c.registerFlagSet(flag.CommandLine)
+}
+
+// RegisterFlagsOf registers only the flags of names, for commands running
+// the harness for a part of their work. it panics for an unknown name.
+func (c *Config) RegisterFlagsOf(names ...string) {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	c.registerFlagSet(fs)
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil {
+			panic(fmt.Sprintf("stats: unknown flag %s", name))
+		}
+		flag.Var(f.Value, f.Name, f.Usage)
+	}
+}
+
+// registerFlagSet registers flags to fs. current values of c are used as
+// defaults, so a copied config can be overridden by another flag set.
+func (c *Config) registerFlagSet(fs *flag.FlagSet) {
+	fs.DurationVar(
 		&c.RunFor,
 		"run_for",
-		5*time.Second,
+		c.RunFor,
 		"how long to run the load test for; 0 to run forever until SIGTERM",
 	)
-	flag.IntVar(
+	fs.IntVar(
 		&c.ReqCount,
 		"req_count",
-		100,
+		c.ReqCount,
 		"number of concurrent requests",
 	)
+	fs.StringVar(
+		&c.Runs,
+		"runs",
+		c.Runs,
+		"named runs to compare, in the form name1=flagsfile1,name2=flagsfile2",
+	)
+	fs.Int64Var(
+		&c.Seed,
+		"seed",
+		c.Seed,
+		"seed for the random generator; 0 to use the current time",
+	)
+	fs.StringVar(
+		&c.Config,
+		"config",
+		c.Config,
+		"manifest file to load flags from; flags on the command line take precedence",
+	)
+	fs.StringVar(
+		&c.Manifest,
+		"manifest",
+		c.Manifest,
+		"file to write the run manifest to",
+	)
+	fs.StringVar(
+		&c.Trace,
+		"trace",
+		c.Trace,
+		"file to write the execution trace of the run to",
+	)
+	fs.IntVar(
+		&c.WritePercent,
+		"write_percent",
+		c.WritePercent,
+		"percentage of write operations",
+	)
+	fs.Var(
+		&ratioValue{percent: &c.WritePercent},
+		"ratio",
+		"write:read ratio such as 1:9; sets the percentage of write operations instead of -write_percent",
+	)
+	fs.Var(
+		&opMixValue{write: &c.WritePercent, ryw: &c.RYWPercent},
+		"op_mix",
+		"relative weights of operations such as read:90,write:10, normalized to percentages; ops are read, write and ryw. sets -write_percent and -ryw_percent instead",
+	)
+	fs.DurationVar(
+		&c.ThinkTime,
+		"think_time",
+		c.ThinkTime,
+		"time for each worker to sleep between operations",
+	)
+	fs.DurationVar(
+		&c.ThinkJitter,
+		"think_jitter",
+		c.ThinkJitter,
+		"max random deviation added to -think_time",
+	)
+	fs.BoolVar(
+		&c.GCStats,
+		"gc_stats",
+		c.GCStats,
+		"sample GC pauses of the harness during the run",
+	)
+	fs.StringVar(
+		&c.Pushgateway,
+		"pushgateway",
+		c.Pushgateway,
+		"URL of Prometheus Pushgateway to push the results to",
+	)
+	fs.StringVar(
+		&c.PushJob,
+		"push_job",
+		c.PushJob,
+		"job label of the results pushed to Pushgateway",
+	)
+	fs.StringVar(
+		&c.Backend,
+		"backend",
+		c.Backend,
+		"backend label of exported metrics",
+	)
+	fs.StringVar(
+		&c.MetricsInstance,
+		"metrics_instance",
+		c.MetricsInstance,
+		"instance label of exported metrics; empty to use the hostname",
+	)
+	fs.IntVar(
+		&c.WritesPerKey,
+		"writes_per_key",
+		c.WritesPerKey,
+		"number of writes to each key before moving on to the next key, wrapping around -keys; 0 to write random keys",
+	)
+	fs.StringVar(
+		&c.HealthAddr,
+		"health_addr",
+		c.HealthAddr,
+		"address to serve /healthz on during the run",
+	)
+	fs.IntVar(
+		&c.TargetQPS,
+		"target_qps",
+		c.TargetQPS,
+		"operations per second to run at most; 0 for unlimited",
+	)
+	fs.StringVar(
+		&c.WorkloadScript,
+		"workload_script",
+		c.WorkloadScript,
+		"file of phases run in sequence; each line is flags of a phase such as -run_for=1m -write_percent=90 -target_qps=500",
+	)
+	fs.StringVar(
+		&c.HgrmFile,
+		"hgrm_file",
+		c.HgrmFile,
+		"file to write latency distributions in HdrHistogram percentile format; the op is inserted before the extension",
+	)
+	fs.IntVar(
+		&c.ClientRetries,
+		"client_retries",
+		c.ClientRetries,
+		"number of times to retry a failed operation on the client side",
+	)
+	fs.DurationVar(
+		&c.RetryBackoff,
+		"retry_backoff",
+		c.RetryBackoff,
+		"base backoff of client retries; doubled on each retry with full jitter",
+	)
+	fs.BoolVar(
+		&c.Plot,
+		"plot",
+		c.Plot,
+		"log latency CDFs as ASCII plots after the run",
+	)
+	fs.StringVar(
+		&c.PlotSVG,
+		"plot_svg",
+		c.PlotSVG,
+		"file to write latency CDFs as SVG plots; the op is inserted before the extension",
+	)
+	fs.BoolVar(
+		&c.PerWorker,
+		"per_worker",
+		c.PerWorker,
+		"record latencies per worker and report the spread of p95 across workers",
+	)
+	fs.Float64Var(
+		&c.OutlierFactor,
+		"outlier_factor",
+		c.OutlierFactor,
+		"factor of the median worker p95 above which a worker is reported as an outlier",
+	)
+	fs.StringVar(
+		&c.EventsFile,
+		"events_file",
+		c.EventsFile,
+		"file to write a sample of operation results to as JSON lines",
+	)
+	fs.IntVar(
+		&c.SampleSize,
+		"sample_size",
+		c.SampleSize,
+		"max number of operation results sampled uniformly for -events_file; 0 to keep all",
+	)
+	fs.BoolVar(
+		&c.FailFast,
+		"fail_fast",
+		c.FailFast,
+		"abort the run on the first fatal backend error such as denied credentials or a missing table",
+	)
+	fs.IntVar(
+		&c.RYWPercent,
+		"ryw_percent",
+		c.RYWPercent,
+		"percentage of read-your-writes operations, which write a key and read it back immediately",
+	)
+	fs.IntVar(
+		&c.Interleave,
+		"interleave",
+		c.Interleave,
+		"number of rounds to alternate -runs in, each for -run_for divided by the rounds, to cancel out drift of the backend",
+	)
+	fs.DurationVar(
+		&c.DeadlineBudget,
+		"deadline_budget",
+		c.DeadlineBudget,
+		"time each operation must complete in including client retries; operations over it are counted as failed deadline misses",
+	)
+	fs.StringVar(
+		&c.ExitSummary,
+		"exit_summary",
+		c.ExitSummary,
+		"file to write a JSON summary of pass/fail, total ops, error rate and p99 latencies in seconds to on exit",
+	)
+	fs.Float64Var(
+		&c.MaxErrorRate,
+		"max_error_rate",
+		c.MaxErrorRate,
+		"max ratio of failed operations for the run to be passed in -exit_summary",
+	)
+	fs.IntVar(
+		&c.Keys,
+		"keys",
+		c.Keys,
+		"number of keys operations are spread over",
+	)
+	fs.IntVar(
+		&c.FinalKeys,
+		"final_keys",
+		c.FinalKeys,
+		"number of keys to grow -keys to linearly over -run_for; 0 to keep the key space fixed",
+	)
+	fs.StringVar(
+		&c.TimelineClock,
+		"timeline_clock",
+		c.TimelineClock,
+		"clock of start times of operations; wall, or monotonic to keep them immune to steps of the wall clock. latencies are always monotonic",
+	)
+	fs.StringVar(
+		&c.HistoryDB,
+		"history_db",
+		c.HistoryDB,
+		"SQLite database to append the flags and the summary of each run to",
+	)
+	fs.BoolVar(
+		&c.KeyAffinity,
+		"key_affinity",
+		c.KeyAffinity,
+		"partition the key space by workers, so each worker operates only on its own keys",
+	)
+	fs.BoolVar(
+		&c.ThrottleBackoff,
+		"throttle_backoff",
+		c.ThrottleBackoff,
+		"halve -target_qps on throttling of the backend, and recover it gradually while not throttled",
+	)
+	fs.StringVar(
+		&c.FoldedStacks,
+		"folded_stacks",
+		c.FoldedStacks,
+		"file to write goroutine stacks of the harness sampled during the run to, in the folded format of flamegraph tools",
+	)
+	fs.DurationVar(
+		&c.StackInterval,
+		"stack_interval",
+		c.StackInterval,
+		"interval of sampling goroutine stacks for -folded_stacks",
+	)
+	fs.StringVar(
+		&c.Binlog,
+		"binlog",
+		c.Binlog,
+		"file to write results of all operations to in a compact binary format; read it by stats.ReadBinlog",
+	)
+	fs.DurationVar(
+		&c.DrainTimeout,
+		"drain_timeout",
+		c.DrainTimeout,
+		"max time to wait for in-flight operations to finish after the run is stopped; operations not finished in time are canceled and not recorded. 0 to wait for all",
+	)
+	fs.IntVar(
+		&c.Bootstrap,
+		"bootstrap",
+		c.Bootstrap,
+		"number of bootstrap resamples of the durations to report 95% confidence intervals of percentiles with; 0 to disable",
+	)
+	fs.BoolVar(
+		&c.Allocs,
+		"allocs",
+		c.Allocs,
+		"report heap allocations of the harness process per operation, sampled around batches of operations",
+	)
+	fs.BoolVar(
+		&c.SteadyState,
+		"steady_state",
+		c.SteadyState,
+		"evaluate -max_error_rate only on operations started after throughput and p95 are stable over 3 windows of -steady_window",
+	)
+	fs.DurationVar(
+		&c.SteadyWindow,
+		"steady_window",
+		c.SteadyWindow,
+		"window of throughput and p95 to detect steady state by -steady_state",
+	)
+	fs.Float64Var(
+		&c.SteadyTolerance,
+		"steady_tolerance",
+		c.SteadyTolerance,
+		"max deviation of throughput and p95 of windows from their mean relative to the mean in steady state",
+	)
+	fs.Float64Var(
+		&c.ChaosErrorRate,
+		"chaos_error_rate",
+		c.ChaosErrorRate,
+		"fraction of calls of operations failed by an injected error without calling the backend, to test retries",
+	)
+	fs.DurationVar(
+		&c.ChaosLatency,
+		"chaos_latency",
+		c.ChaosLatency,
+		"latency injected before calls of operations at -chaos_latency_rate, to test timeouts",
+	)
+	fs.Float64Var(
+		&c.ChaosLatencyRate,
+		"chaos_latency_rate",
+		c.ChaosLatencyRate,
+		"fraction of calls of operations delayed by -chaos_latency",
+	)
+	fs.IntVar(
+		&c.StopAfterErrors,
+		"stop_after_errors",
+		c.StopAfterErrors,
+		"stop the run once this number of operations failed in total; 0 to run regardless of errors",
+	)
+	fs.BoolVar(
+		&c.Autocorrelation,
+		"autocorrelation",
+		c.Autocorrelation,
+		"report the lag 1 autocorrelation of latencies of consecutive operations of each worker, which is high if slow operations cause subsequent slow operations",
+	)
+	fs.IntVar(
+		&c.TotalOps,
+		"total_ops",
+		c.TotalOps,
+		"stop the run once this number of operations are done, or -run_for elapses; 0 to run for -run_for",
+	)
+	fs.DurationVar(
+		&c.MinRunDuration,
+		"min_run_duration",
+		c.MinRunDuration,
+		"spread -total_ops over at least this duration by throttling, so a fast backend doesn't finish them too quickly to be meaningful",
+	)
+	fs.DurationVar(
+		&c.WriteDelay,
+		"write_delay",
+		c.WriteDelay,
+		"fixed delay added to every call of writes, modeling the network latency of writes to a remote region",
+	)
+	fs.StringVar(
+		&c.SummaryFile,
+		"summary_file",
+		c.SummaryFile,
+		"file to write the summary of the run with quantile sketches to, which are merged with those of other instances by stats.MergeSummaries",
+	)
+	fs.BoolVar(
+		&c.Estimate,
+		"estimate",
+		c.Estimate,
+		"print the projected number of ops and bytes of the run by the flags, and exit without running",
+	)
+	fs.BoolVar(
+		&c.Utilization,
+		"utilization",
+		c.Utilization,
+		"report the fraction of time workers spend running operations; near 100% means the harness is the bottleneck of the offered load",
+	)
+	fs.BoolVar(
+		&c.UniqueKeys,
+		"unique_keys",
+		c.UniqueKeys,
+		"report the number of distinct keys of operations of each op and the repeat ratio, to validate the key distribution",
+	)
+	fs.BoolVar(
+		&c.ExcludeFailedLatency,
+		"exclude_failed_latency",
+		c.ExcludeFailedLatency,
+		"record latencies of successful operations only, so fast failures don't lower the percentiles; failures are still counted in tries",
+	)
+	fs.BoolVar(
+		&c.IntegritySweep,
+		"integrity_sweep",
+		c.IntegritySweep,
+		"read every key written during the run after the run, and report missing keys and failed reads",
+	)
+}
+
+// ParseFlags parses the command line flags, and then applies flags recorded
+// in the manifest given by -config.
+func (c *Config) ParseFlags() error {
+	flag.Parse()
+	if err := checkExclusiveFlags(flag.CommandLine); err != nil {
+		return err
+	}
+	if c.Config == "" {
+		return nil
+	}
+	return loadManifestFlags(flag.CommandLine, c.Config)
+}
+
+// MultiRun reports whether the config runs multiple runs by StartRuns.
+func (c Config) MultiRun() bool {
+	return c.Runs != "" || c.WorkloadScript != ""
 }
 
 func (c Config) Validate() error {
-	return validator.New().Struct(c)
+	if err := validator.New().Struct(c); err != nil {
+		return &ConfigError{Err: err}
+	}
+	if c.FinalKeys != 0 && c.FinalKeys < c.Keys {
+		return &ConfigError{Err: fmt.Errorf("-final_keys %d is less than -keys %d", c.FinalKeys, c.Keys)}
+	}
+	if c.ThrottleBackoff && c.TargetQPS == 0 {
+		return &ConfigError{Err: errors.New("-throttle_backoff requires -target_qps")}
+	}
+	if c.KeyAffinity && c.Keys < c.ReqCount {
+		return &ConfigError{Err: fmt.Errorf("-keys %d is less than -req_count %d to partition by -key_affinity", c.Keys, c.ReqCount)}
+	}
+	if c.MinRunDuration > 0 && c.TotalOps == 0 {
+		return &ConfigError{Err: errors.New("-min_run_duration requires -total_ops")}
+	}
+	if c.WritePercent+c.RYWPercent > 100 {
+		return &ConfigError{Err: fmt.Errorf("sum of -write_percent and -ryw_percent is %d, over 100", c.WritePercent+c.RYWPercent)}
+	}
+	return nil
 }
 
 type StatsFunc func(ctx context.Context, id int) error
 
 type Stats struct {
 	Config *Config
+	// GC is GC activity during the last run; nil unless -gc_stats is set.
+	GC *GCStats
+	// Allocs is allocations per operation during the last run; nil unless
+	// -allocs is set.
+	Allocs *AllocStats
+	// Heartbeat checks the backend periodically while serving /healthz.
+	Heartbeat func(ctx context.Context) error
+	// Workers are operations of each worker during the last run; nil unless
+	// -per_worker is set.
+	Workers []*Recorder
+	// RYW is read-your-writes operations during the last run; nil unless
+	// -ryw_percent is set.
+	RYW *RYWStats
+	// QueueDelay is time operations waited for a worker after they were
+	// released during the last run. operations are released by -target_qps
+	// and by the slots of -min_run_duration; otherwise they are released when
+	// a worker takes them, so the delay is near zero unless workers are
+	// saturated by pacing.
+	QueueDelay *Recorder
+	// Validate checks the result of each operation, where op is read,
+	// write or ryw and err is the error of the operation. returning an error
+	// fails the operation, in the category of a *ValidationError.
+	Validate func(op string, id int, err error) error
+	// Done stops the run when it's closed, in addition to -run_for and
+	// signals.
+	Done <-chan struct{}
+	// Sweep reads a key written during the run under -integrity_sweep, and
+	// returns ErrMissing if the key is missing. the read of the run is used
+	// if nil.
+	Sweep func(ctx context.Context, id int) error
+	// AppendHistory appends the run to the database at path under
+	// -history_db. it's set by commands linking a database driver, such as
+	// history.Append; -history_db is rejected if nil.
+	AppendHistory func(path string, run *HistoryRun) error
+	// Fatal reports whether an error of an operation aborts the run under
+	// -fail_fast; IsFatalStatus is used if nil.
+	Fatal func(err error) bool
+	// Throttled reports whether an error of an operation is throttling of the
+	// backend under -throttle_backoff; IsThrottleStatus is used if nil.
+	Throttled func(err error) bool
+
+	// signals stops the run by SIGINT and SIGTERM. it's set by StartRuns to
+	// share a handler among runs; Start installs its own if nil.
+	signals <-chan os.Signal
+	// signaled is whether the last run is stopped by a signal.
+	signaled bool
+
+	events   *reservoir
+	allocs   *allocSampler
+	steady   *steadyState
+	chaos    *chaos
+	keys     *keyTracker
+	written  *keySet
+	binlog   *binlog
+	throttle *throttle
+	invalid  validations
+	abort    chan error
+	errLimit chan struct{}
+	failures int64
+	skipped  int64
+	issued   int64
+	started  time.Time
+	writes   int64
+
+	// recording guards abandoned against operations being recorded.
+	recording sync.RWMutex
+	abandoned bool
+	inFlight  int64
 }
 
 func NewStats(conf *Config) *Stats {
+	if conf.Seed == 0 {
+		conf.Seed = time.Now().UnixNano()
+	}
 	return &Stats{Config: conf}
 }
 
-func (s Stats) Start(readFunc, writeFunc StatsFunc) (read, write Recorder, err error) {
+func (s *Stats) Start(readFunc, writeFunc StatsFunc) (read, write Recorder, err error) {
 	if !flag.Parsed() {
 		flag.Parse()
 	}
 	if err = s.Config.Validate(); err != nil {
 		return
 	}
+	if s.Config.HistoryDB != "" && s.AppendHistory == nil {
+		err = &ConfigError{Err: errNoHistory}
+		return
+	}
+
+	rand.Seed(s.Config.Seed)
+
+	if s.Config.Trace != "" {
+		var stopTrace func()
+		if stopTrace, err = startTrace(s.Config.Trace); err != nil {
+			return
+		}
+		defer stopTrace()
+	}
+
+	if s.Config.GCStats {
+		sampler := startGCSampler(gcSampleInterval)
+		defer func() {
+			s.GC = sampler.stop(&read, &write)
+		}()
+	}
+
+	if s.Config.FoldedStacks != "" {
+		sampler := startStackSampler(s.Config.StackInterval)
+		defer func() {
+			if err := sampler.stop(s.Config.FoldedStacks); err != nil {
+				log.Printf("Error writing folded stacks: %v", err)
+			}
+		}()
+	}
+
+	if s.Config.HealthAddr != "" {
+		h := startHealth(s.Config.HealthAddr, s.Heartbeat)
+		defer h.stop()
+	}
 
 	var (
-		ctx      = context.Background()
-		sem      = make(chan struct{}, s.Config.ReqCount)
-		wg       sync.WaitGroup
-		stopTime = time.Now().Add(s.Config.RunFor)
+		ctx, cancel = context.WithCancel(context.Background())
+		wg          sync.WaitGroup
+		done        = make(chan struct{})
+		stop        = s.signals
+		timeout     <-chan time.Time
 	)
+	defer cancel()
+	if stop == nil {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(c)
+		stop = c
+	}
+	s.signaled = false
+	s.abort = make(chan error, 1)
+	s.errLimit = make(chan struct{}, 1)
+	s.failures = 0
+	s.skipped = 0
+	s.issued = 0
+	s.started = time.Now()
+	s.abandoned = false
+
+	s.binlog = nil
+	if s.Config.Binlog != "" {
+		if s.binlog, err = createBinlog(s.Config.Binlog, s.started); err != nil {
+			return
+		}
+	}
+
+	var tokens <-chan time.Time
+	s.QueueDelay = new(Recorder)
+	s.throttle = nil
+	s.invalid = validations{}
+	if s.Config.TargetQPS > 0 {
+		interval := time.Second / time.Duration(s.Config.TargetQPS)
+		next := func() time.Duration { return interval }
+		if s.Config.ThrottleBackoff {
+			s.throttle = newThrottle(s.Config.TargetQPS)
+			s.throttle.start(done)
+			next = s.throttle.interval
+		}
+		tokens = startLimiter(next, done)
+	}
+
+	s.events = nil
+	if s.Config.EventsFile != "" {
+		s.events = newReservoir(s.Config.SampleSize)
+	}
+
+	s.RYW = nil
+	if s.Config.RYWPercent > 0 {
+		s.RYW = new(RYWStats)
+	}
+
+	s.Workers = nil
+	if s.Config.PerWorker {
+		s.Workers = make([]*Recorder, s.Config.ReqCount)
+		for i := range s.Workers {
+			s.Workers[i] = new(Recorder)
+		}
+	}
+
+	s.allocs = nil
+	if s.Config.Allocs {
+		s.allocs = startAllocSampler()
+	}
+
+	s.steady = nil
+	if s.Config.SteadyState {
+		s.steady = newSteadyState(s.started, s.Config.SteadyWindow, s.Config.SteadyTolerance)
+	}
+
+	s.keys = nil
+	if s.Config.UniqueKeys {
+		s.keys = newKeyTracker()
+	}
+
+	s.written = nil
+	if s.Config.IntegritySweep {
+		s.written = newKeySet()
+	}
+	// the sweep reads without injected latency and errors.
+	sweepRead := readFunc
+
+	if s.Config.WriteDelay > 0 {
+		writeFunc = delayed(writeFunc, s.Config.WriteDelay)
+	}
+	if s.chaos = newChaos(s.Config); s.chaos != nil {
+		readFunc, writeFunc = s.chaos.wrap(readFunc), s.chaos.wrap(writeFunc)
+	}
 
-	for time.Now().Before(stopTime) || s.Config.RunFor == 0 {
-		sem <- struct{}{}
+	// each worker runs operations one by one, so ReqCount operations are
+	// running concurrently at most.
+	workers := make([]*worker, s.Config.ReqCount)
+	for i := range workers {
+		w := &worker{index: i}
+		if s.Workers != nil {
+			w.rec = s.Workers[i]
+		}
+		workers[i] = w
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			defer func() { <-sem }()
-			var (
-				ok      = true
-				opStart = time.Now()
-				rec     *Recorder
-			)
-			defer func() {
-				rec.record(ok, time.Since(opStart))
-			}()
-
-			id := rand.Intn(100)
-			switch rand.Intn(10) {
-			case 0, 1, 2, 3, 4: // write
-				rec = &write
-				if err := writeFunc(ctx, id); err != nil {
-					log.Printf("Error doing write: %v", err)
-					ok = false
+			defer s.endWorker(w)
+			for {
+				select {
+				case <-done:
+					return
+				default:
 				}
-			default: // read
-				rec = &read
-				if err := readFunc(ctx, id); err != nil {
-					log.Printf("Error doing read: %v", err)
-					ok = false
+				released, ok := s.takeOp(done)
+				if !ok {
+					return
+				}
+				if tokens != nil {
+					select {
+					case <-done:
+						return
+					case t := <-tokens:
+						// the op is released by the later of its slot and
+						// its token.
+						if t.After(released) {
+							released = t
+						}
+					}
+				}
+				if released.IsZero() {
+					// unpaced ops are released when the worker takes them.
+					released = time.Now()
+				}
+				s.QueueDelay.recordAt(true, released, time.Since(released))
+				s.do(ctx, readFunc, writeFunc, &read, &write, w)
+				if !s.think(done) {
+					return
 				}
 			}
 		}()
 	}
+
+	if s.Config.RunFor > 0 {
+		timeout = time.After(s.Config.RunFor)
+	}
+	finished := s.opsDone(&wg)
+	var abortErr error
+	select {
+	case sig := <-stop:
+		log.Printf("Stopping by %v", sig)
+		s.signaled = true
+	case abortErr = <-s.abort:
+		log.Printf("Aborting by fatal error: %v", abortErr)
+	case <-s.errLimit:
+		log.Printf("Stopping after %d errors", s.Config.StopAfterErrors)
+	case <-s.Done:
+	case <-timeout:
+	case <-finished:
+		s.warnShortRun(time.Since(s.started))
+	}
+	close(done)
+	s.drain(&wg, cancel)
+	if n := atomic.LoadInt64(&s.skipped); n > 0 {
+		log.Printf("Skipped %d ops with nothing to do", n)
+	}
+	if s.chaos != nil {
+		log.Printf("Chaos: %v", s.chaos)
+	}
+	if s.allocs != nil {
+		s.Allocs = s.allocs.stop()
+		log.Printf("Allocations:\n%v", s.Allocs)
+	}
+
+	if s.binlog != nil {
+		if err := s.binlog.close(); err != nil {
+			log.Printf("Error writing binlog: %v", err)
+		}
+	}
+	if s.RYW != nil {
+		log.Printf("Read-your-writes (%d ok / %d tries):\n%v", s.RYW.Combined.Ok, s.RYW.Combined.Tries, s.RYW.Combined.Aggregate())
+		log.Printf("Read-your-writes read leg (%d ok / %d tries):\n%v", s.RYW.Read.Ok, s.RYW.Read.Tries, s.RYW.Read.Aggregate())
+	}
+	if s.Workers != nil {
+		log.Printf("Workers:\n%v", workerReport(s.Workers, s.Config.OutlierFactor))
+	}
+	if s.keys != nil {
+		log.Printf("Unique keys:\n%v", s.keys)
+	}
+	if s.written != nil {
+		log.Printf("Integrity sweep:\n%v", s.sweep(context.Background(), sweepRead))
+	}
+	if s.Config.Utilization {
+		log.Printf("Worker utilization: %v", utilizationReport(workers, s.started))
+	}
+	if s.Config.Autocorrelation {
+		seqs := make([][]float64, len(workers))
+		for i, w := range workers {
+			seqs[i] = w.latencies
+		}
+		log.Printf("Autocorrelation of consecutive latencies:\n%v", autocorrelationReport(seqs))
+	}
+	if s.Validate != nil {
+		log.Printf("Validation failures:\n%v", &s.invalid)
+	}
+	if s.throttle != nil {
+		log.Printf("Throttling:\n%v", s.throttle)
+	}
+	service := new(Recorder)
+	service.merge(&read)
+	service.merge(&write)
+	log.Printf("Queue delay (%d ops):\n%v", s.QueueDelay.Tries, s.QueueDelay.Aggregate())
+	log.Printf("Service time (%d ops):\n%v", service.Tries, service.Aggregate())
+	if s.Config.DeadlineBudget > 0 {
+		log.Printf(
+			"Deadline misses: reads %d / %d (%.2f%%), writes %d / %d (%.2f%%)",
+			read.Misses, read.Tries, read.MissRate()*100,
+			write.Misses, write.Tries, write.MissRate()*100,
+		)
+	}
+	if s.Config.ClientRetries > 0 {
+		log.Printf(
+			"Client retries: reads amplified %.2fx (%d calls / %d ops), writes amplified %.2fx (%d calls / %d ops)",
+			read.Amplification(), read.Attempts, read.Tries,
+			write.Amplification(), write.Attempts, write.Tries,
+		)
+	}
+	if s.Config.Bootstrap > 0 {
+		log.Printf("Percentiles with confidence intervals (%d resamples):\n%v",
+			s.Config.Bootstrap, s.bootstrapReport(map[string]*Recorder{"read": &read, "write": &write}))
+	}
+	if s.events != nil {
+		log.Printf("Events: sampled %d of %d ops", len(s.events.events), s.events.seen)
+		if err := s.events.writeFile(s.Config.EventsFile); err != nil {
+			log.Printf("Error writing events: %v", err)
+		}
+	}
+	if s.Config.HgrmFile != "" {
+		if err := s.writeHgrms(map[string]*Recorder{"read": &read, "write": &write}); err != nil {
+			log.Printf("Error writing hgrm: %v", err)
+		}
+	}
+	if s.Config.Plot || s.Config.PlotSVG != "" {
+		if err := s.plot(map[string]*Recorder{"read": &read, "write": &write}); err != nil {
+			log.Printf("Error plotting: %v", err)
+		}
+	}
+	if s.Config.Pushgateway != "" {
+		if err := s.push(&read, &write); err != nil {
+			log.Printf("Error pushing results: %v", err)
+		}
+	}
+	if s.Config.ExitSummary != "" {
+		if err := s.writeExitSummary(s.newExitSummary(&read, &write, abortErr != nil)); err != nil {
+			log.Printf("Error writing exit summary: %v", err)
+		}
+	}
+	if s.Config.HistoryDB != "" {
+		if err := s.appendHistory(&read, &write, abortErr != nil); err != nil {
+			log.Printf("Error appending history: %v", err)
+		}
+	}
+	if s.Config.SummaryFile != "" {
+		if err := s.writeSummary(map[string]*Recorder{"read": &read, "write": &write}); err != nil {
+			log.Printf("Error writing summary: %v", err)
+		}
+	}
+	if abortErr != nil {
+		err = &AbortError{Reason: "fatal backend error", Err: abortErr}
+	}
 	return
 }
 
+// endWorker records when w exited unless it's abandoned by -drain_timeout.
+func (s *Stats) endWorker(w *worker) {
+	s.recording.RLock()
+	defer s.recording.RUnlock()
+	if !s.abandoned {
+		w.ended = time.Now()
+	}
+}
+
+// worker is a goroutine running operations in Start.
+type worker struct {
+	index int
+	// rec records operations of the worker if -per_worker is set.
+	rec *Recorder
+	// latencies are of operations of the worker in order if
+	// -autocorrelation is set.
+	latencies []float64
+	// busy is the time the worker spent running operations, and ended is
+	// when the worker exited; zero if it's abandoned.
+	busy  time.Duration
+	ended time.Time
+}
+
+// do runs an operation of w and records it to read or write.
+func (s *Stats) do(ctx context.Context, readFunc, writeFunc StatsFunc, read, write *Recorder, w *worker) {
+	var (
+		ok       = true
+		opStart  = time.Now()
+		rec      *Recorder
+		op       string
+		id       = s.pickKey(w.index)
+		attempts int
+		err      error
+		desc     string
+	)
+	if budget := s.Config.DeadlineBudget; budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+	ctx, ex := withExcluded(ctx)
+	atomic.AddInt64(&s.inFlight, 1)
+	defer func() {
+		atomic.AddInt64(&s.inFlight, -1)
+		// time.Since uses the monotonic reading of opStart. time excluded by
+		// the operation, such as by compression, isn't its latency.
+		d := time.Since(opStart) - ex.get()
+		s.recording.RLock()
+		defer s.recording.RUnlock()
+		if s.abandoned {
+			return
+		}
+		// a skipped operation is counted only.
+		if errors.Is(err, ErrSkipped) {
+			atomic.AddInt64(&s.skipped, 1)
+			return
+		}
+		if s.allocs != nil {
+			s.allocs.add()
+		}
+		// a missed deadline fails the operation.
+		missed := s.Config.DeadlineBudget > 0 && d > s.Config.DeadlineBudget
+		if missed {
+			ok = false
+		}
+		if s.steady != nil {
+			s.steady.add(op, opStart, d, ok)
+		}
+		// the limit is reached by exactly one failure, which stops the run.
+		if !ok && s.Config.StopAfterErrors > 0 && atomic.AddInt64(&s.failures, 1) == int64(s.Config.StopAfterErrors) {
+			s.errLimit <- struct{}{}
+		}
+		start := s.timeline(opStart)
+		// a failed operation is only counted under -exclude_failed_latency.
+		withLatency := ok || !s.Config.ExcludeFailedLatency
+		if withLatency {
+			rec.record(ok, start, d)
+		} else {
+			rec.countFailure()
+		}
+		rec.addAttempts(attempts)
+		if missed {
+			rec.addMiss()
+		}
+		if w.rec != nil {
+			if withLatency {
+				w.rec.recordAt(ok, start, d)
+			} else {
+				w.rec.countFailure()
+			}
+		}
+		if s.Config.Autocorrelation {
+			w.latencies = append(w.latencies, float64(d))
+		}
+		w.busy += d
+		if s.keys != nil {
+			s.keys.add(op, id)
+		}
+		if s.written != nil && ok && (op == "write" || op == "ryw") {
+			s.written.add(id)
+		}
+		if s.events != nil {
+			s.events.add(event{Op: op, ID: id, Start: start, Duration: d, Ok: ok})
+		}
+		if s.binlog != nil {
+			s.binlog.add(op, opStart, d, ok)
+		}
+	}()
+
+	roll := rand.Intn(100)
+	switch {
+	case roll < s.Config.WritePercent: // write
+		rec, op, desc = write, "write", "write"
+		// keys are written n times each in order, and wrapped around -keys.
+		if n := s.Config.WritesPerKey; n > 0 {
+			id = int(atomic.AddInt64(&s.writes, 1)-1) / n % s.Config.Keys
+		}
+		attempts, err = s.call(ctx, writeFunc, id)
+	case roll < s.Config.WritePercent+s.Config.RYWPercent: // read your write
+		rec, op, desc = &s.RYW.Combined, "ryw", "read-your-write"
+		attempts, err = s.readYourWrite(ctx, readFunc, writeFunc, id)
+	default: // read
+		rec, op, desc = read, "read", "read"
+		attempts, err = s.call(ctx, readFunc, id)
+	}
+	if errors.Is(err, ErrSkipped) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error doing %s: %v", desc, err)
+		ok = false
+		s.failFast(err)
+		s.backOff(err)
+	}
+	if !s.validate(op, id, err) {
+		ok = false
+	}
+}
+
+// startLimiter returns a channel which releases a token at every interval
+// returned by next until done is closed. the next token is scheduled after a
+// worker takes the last one, so tokens don't burst. each token is the time
+// it's released at, to measure the queue delay until a worker takes it.
+func startLimiter(next func() time.Duration, done <-chan struct{}) <-chan time.Time {
+	tokens := make(chan time.Time)
+	go func() {
+		for {
+			var released time.Time
+			select {
+			case <-done:
+				return
+			case released = <-time.After(next()):
+			}
+			select {
+			case <-done:
+				return
+			case tokens <- released:
+			}
+		}
+	}()
+	return tokens
+}
+
+// think sleeps for the think time with jitter between operations. it returns
+// false if done is closed while sleeping.
+func (s *Stats) think(done <-chan struct{}) bool {
+	d := s.Config.ThinkTime
+	if j := s.Config.ThinkJitter; j > 0 {
+		d += time.Duration(rand.Int63n(int64(2*j))) - j
+	}
+	if d <= 0 {
+		return true
+	}
+	select {
+	case <-done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
 type Recorder struct {
-	mu        sync.Mutex
-	Tries     int
-	Ok        int
+	mu    sync.Mutex
+	Tries int
+	Ok    int
+	// Attempts is the number of calls to the backend including client
+	// retries; it is only counted for operations run by Start.
+	Attempts int
+	// Misses is the number of operations over -deadline_budget.
+	Misses    int
 	durations []float64
+	starts    []time.Time
+}
+
+func (r *Recorder) record(ok bool, start time.Time, d time.Duration) {
+	r.recordAt(ok, start, d)
+	if n := atomic.AddInt64(&allStats, 1); n%1000 == 0 {
+		log.Printf("Progress: done %d ops", n)
+	}
+}
+
+// Record records a result of an operation measured outside of Stats.
+// the operation is assumed to have just finished.
+func (r *Recorder) Record(ok bool, d time.Duration) {
+	r.recordAt(ok, time.Now().Add(-d), d)
 }
 
-func (r *Recorder) record(ok bool, d time.Duration) {
+func (r *Recorder) recordAt(ok bool, start time.Time, d time.Duration) {
 	r.mu.Lock()
 	r.Tries++
 	if ok {
 		r.Ok++
 	}
 	r.durations = append(r.durations, float64(d))
+	r.starts = append(r.starts, start)
 	r.mu.Unlock()
-	if n := atomic.AddInt64(&allStats, 1); n%1000 == 0 {
-		log.Printf("Progress: done %d ops", n)
+}
+
+// countFailure counts a failed operation without its latency.
+func (r *Recorder) countFailure() {
+	r.mu.Lock()
+	r.Tries++
+	r.mu.Unlock()
+}
+
+func (r *Recorder) addAttempts(n int) {
+	r.mu.Lock()
+	r.Attempts += n
+	r.mu.Unlock()
+}
+
+// merge adds operations recorded by o to r.
+func (r *Recorder) merge(o *Recorder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Tries += o.Tries
+	r.Ok += o.Ok
+	r.Attempts += o.Attempts
+	r.Misses += o.Misses
+	r.durations = append(r.durations, o.durations...)
+	r.starts = append(r.starts, o.starts...)
+}
+
+func (r *Recorder) addMiss() {
+	r.mu.Lock()
+	r.Misses++
+	r.mu.Unlock()
+}
+
+// MissRate returns the ratio of operations over -deadline_budget.
+func (r *Recorder) MissRate() float64 {
+	if r.Tries == 0 {
+		return 0
+	}
+	return float64(r.Misses) / float64(r.Tries)
+}
+
+// Amplification returns the number of calls to the backend per operation,
+// which is more than 1 when failed operations are retried.
+func (r *Recorder) Amplification() float64 {
+	if r.Tries == 0 {
+		return 0
 	}
+	return float64(r.Attempts) / float64(r.Tries)
+}
+
+// Percentile returns the p-th percentile of recorded durations.
+func (r *Recorder) Percentile(p float64) time.Duration {
+	d, _ := stats.Percentile(r.durations, p)
+	return time.Duration(d)
 }
 
 func (r *Recorder) Aggregate() string {
@@ -135,7 +1267,12 @@ func (r *Recorder) Aggregate() string {
 		tile75, _ = stats.Percentile(r.durations, 75)
 		tile95, _ = stats.Percentile(r.durations, 95)
 		tile99, _ = stats.Percentile(r.durations, 99)
+		cov       = r.CoV()
+		unstable  string
 	)
+	if cov > covThreshold {
+		unstable = " (high; the latency may be unstable)"
+	}
 	return fmt.Sprintf(
 		"min: %v\n"+
 			"max: %v\n"+
@@ -144,7 +1281,8 @@ func (r *Recorder) Aggregate() string {
 			"50th percentile: %v\n"+
 			"75th percentile: %v\n"+
 			"95th percentile: %v\n"+
-			"99th percentile: %v\n",
+			"99th percentile: %v\n"+
+			"coefficient of variation: %.3f%s\n",
 		time.Duration(min),
 		time.Duration(max),
 		time.Duration(medi),
@@ -153,5 +1291,21 @@ func (r *Recorder) Aggregate() string {
 		time.Duration(tile75),
 		time.Duration(tile95),
 		time.Duration(tile99),
+		cov, unstable,
 	)
 }
+
+// covThreshold is the coefficient of variation above which the latency is
+// flagged as unstable.
+const covThreshold = 1
+
+// CoV returns the coefficient of variation of recorded durations, which is
+// the standard deviation divided by the mean.
+func (r *Recorder) CoV() float64 {
+	mean, _ := stats.Mean(r.durations)
+	if mean == 0 {
+		return 0
+	}
+	stddev, _ := stats.StandardDeviation(r.durations)
+	return stddev / mean
+}
@@ -0,0 +1,204 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThinkTime(t *testing.T) {
+	run := func(think time.Duration) int {
+		conf := NewConfig()
+		conf.RunFor = 200 * time.Millisecond
+		conf.ReqCount = 2
+		conf.ThinkTime = think
+		conf.ThinkJitter = think / 2
+		op := func(ctx context.Context, id int) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		}
+		read, write, err := NewStats(conf).Start(op, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return read.Tries + write.Tries
+	}
+
+	var (
+		busy     = run(0)
+		thinking = run(20 * time.Millisecond)
+		// each worker runs an operation at most every 10ms, the think time
+		// less the jitter.
+		max = 2 * (200/10 + 1)
+	)
+	if thinking >= busy || thinking > max {
+		t.Errorf("%d ops with think time, want less than %d ops without it and at most %d", thinking, busy, max)
+	}
+}
+
+func TestGCStats(t *testing.T) {
+	conf := NewConfig()
+	conf.RunFor = 100 * time.Millisecond
+	conf.ReqCount = 2
+	conf.GCStats = true
+	var sink atomic.Value
+	op := func(ctx context.Context, id int) error {
+		sink.Store(make([]byte, 1<<20))
+		runtime.GC()
+		return nil
+	}
+	s := NewStats(conf)
+	if _, _, err := s.Start(op, op); err != nil {
+		t.Fatal(err)
+	}
+
+	gc := s.GC
+	if gc == nil {
+		t.Fatal("GC stats aren't captured")
+	}
+	if gc.NumGC == 0 || gc.PauseTotal < 0 || gc.MaxPause < 0 || gc.MaxPause > gc.PauseTotal {
+		t.Errorf("GC stats = %+v, want GC cycles with non-negative pauses", gc)
+	}
+	if gc.SlowOps < 0 || gc.SlowOpsInGC < 0 || gc.SlowOpsInGC > gc.SlowOps {
+		t.Errorf("GC stats = %+v, want slow ops in GC within slow ops", gc)
+	}
+}
+
+func TestWritesPerKey(t *testing.T) {
+	const n = 3
+	conf := NewConfig()
+	conf.RunFor = 50 * time.Millisecond
+	conf.ReqCount = 4
+	conf.WritePercent = 100
+	conf.WritesPerKey = n
+	var (
+		mu     sync.Mutex
+		writes = make(map[int]int)
+		read   = func(ctx context.Context, id int) error { return nil }
+		write  = func(ctx context.Context, id int) error {
+			mu.Lock()
+			writes[id]++
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			return nil
+		}
+	)
+	if _, _, err := NewStats(conf).Start(read, write); err != nil {
+		t.Fatal(err)
+	}
+
+	// keys are written in order, and the last key may be written partially
+	// when the run ends.
+	if len(writes) < 2 {
+		t.Fatalf("writes = %v, want more keys written", writes)
+	}
+	for id := 0; id < len(writes)-1; id++ {
+		if writes[id] != n {
+			t.Errorf("key %d is written %d times, want %d", id, writes[id], n)
+		}
+	}
+	if last := writes[len(writes)-1]; last < 1 || last > n {
+		t.Errorf("last key is written %d times, want 1 to %d", last, n)
+	}
+}
+
+func TestWritesPerKeyWraps(t *testing.T) {
+	const (
+		n    = 3
+		keys = 5
+	)
+	conf := NewConfig()
+	conf.RunFor = 10 * time.Second
+	conf.ReqCount = 4
+	conf.WritePercent = 100
+	conf.WritesPerKey = n
+	conf.Keys = keys
+	// the keys are written around twice.
+	conf.TotalOps = 2 * keys * n
+	var (
+		mu     sync.Mutex
+		writes = make(map[int]int)
+		read   = func(ctx context.Context, id int) error { return nil }
+		write  = func(ctx context.Context, id int) error {
+			mu.Lock()
+			writes[id]++
+			mu.Unlock()
+			return nil
+		}
+	)
+	if _, _, err := NewStats(conf).Start(read, write); err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != keys {
+		t.Errorf("writes = %v, want %d keys", writes, keys)
+	}
+	for id := 0; id < keys; id++ {
+		if writes[id] != 2*n {
+			t.Errorf("key %d is written %d times, want %d", id, writes[id], 2*n)
+		}
+	}
+}
+
+func TestDeadlineBudget(t *testing.T) {
+	conf := NewConfig()
+	conf.RunFor = 200 * time.Millisecond
+	conf.ReqCount = 4
+	conf.DeadlineBudget = 10 * time.Millisecond
+	// operations of half of the keys take over the budget.
+	op := func(ctx context.Context, id int) error {
+		if id%2 == 0 {
+			time.Sleep(20 * time.Millisecond)
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+		return nil
+	}
+	read, write, err := NewStats(conf).Start(op, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, rec := range map[string]*Recorder{"read": &read, "write": &write} {
+		if rec.Misses == 0 || rec.Misses == rec.Tries {
+			t.Errorf("%s misses = %d / %d, want slow ops only", name, rec.Misses, rec.Tries)
+		}
+		if rec.Ok+rec.Misses != rec.Tries {
+			t.Errorf("%s = %d ok + %d misses, want %d tries with misses failed", name, rec.Ok, rec.Misses, rec.Tries)
+		}
+		if rate := rec.MissRate(); rate != float64(rec.Misses)/float64(rec.Tries) {
+			t.Errorf("%s miss rate = %v, want misses / tries", name, rate)
+		}
+	}
+}
+
+func TestStopAfterErrors(t *testing.T) {
+	conf := NewConfig()
+	conf.RunFor = time.Hour
+	conf.ReqCount = 4
+	conf.StopAfterErrors = 20
+	// a quarter of the operations fail.
+	var calls int64
+	op := func(ctx context.Context, id int) error {
+		time.Sleep(time.Millisecond)
+		if atomic.AddInt64(&calls, 1)%4 == 0 {
+			return errors.New("failed")
+		}
+		return nil
+	}
+	start := time.Now()
+	read, write, err := NewStats(conf).Start(op, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("run took %v, want it stopped by the errors", elapsed)
+	}
+	// operations in flight when the limit is reached fail after it.
+	failures := read.Tries - read.Ok + write.Tries - write.Ok
+	if failures < conf.StopAfterErrors || failures > conf.StopAfterErrors+conf.ReqCount {
+		t.Errorf("stopped after %d errors, want about %d", failures, conf.StopAfterErrors)
+	}
+}
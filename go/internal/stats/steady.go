@@ -0,0 +1,120 @@
+package stats
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/montanaflynn/stats"
+)
+
+// steadyWindows is the number of consecutive windows whose throughput and
+// p95 have to be within -steady_tolerance to declare steady state.
+const steadyWindows = 3
+
+// windowStat is the throughput and the p95 latency of a window.
+type windowStat struct {
+	qps, p95 float64
+}
+
+// steadyState detects steady state of a run by throughput and p95 of windows
+// of -steady_window, and records operations started after it, so the SLO of
+// -max_error_rate is evaluated without transient startup.
+type steadyState struct {
+	mu        sync.Mutex
+	window    time.Duration
+	tolerance float64
+	// end is the end of the current window.
+	end       time.Time
+	durations []float64
+	history   []windowStat
+	// at is when steady state is declared; zero until then.
+	at          time.Time
+	read, write Recorder
+}
+
+func newSteadyState(started time.Time, window time.Duration, tolerance float64) *steadyState {
+	return &steadyState{
+		window:    window,
+		tolerance: tolerance,
+		end:       started.Add(window),
+	}
+}
+
+// add adds an operation of op started at start.
+func (s *steadyState) add(op string, start time.Time, d time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.at.IsZero() {
+		s.advance(start.Add(d))
+	}
+	if s.at.IsZero() {
+		s.durations = append(s.durations, float64(d))
+		return
+	}
+	if start.Before(s.at) {
+		return
+	}
+	switch op {
+	case "read":
+		s.read.recordAt(ok, start, d)
+	case "write":
+		s.write.recordAt(ok, start, d)
+	}
+}
+
+// advance closes windows ended by now until steady state is declared.
+func (s *steadyState) advance(now time.Time) {
+	for s.at.IsZero() && !now.Before(s.end) {
+		p95, _ := stats.Percentile(s.durations, 95)
+		s.history = append(s.history, windowStat{
+			qps: float64(len(s.durations)) / s.window.Seconds(),
+			p95: p95,
+		})
+		s.durations = s.durations[:0]
+		if s.stable() {
+			s.at = s.end
+			log.Printf("Steady state after %d windows of %v", len(s.history), s.window)
+		}
+		s.end = s.end.Add(s.window)
+	}
+}
+
+// stable reports whether the throughput and p95 of the last windows deviate
+// from their mean within the tolerance.
+func (s *steadyState) stable() bool {
+	if len(s.history) < steadyWindows {
+		return false
+	}
+	last := s.history[len(s.history)-steadyWindows:]
+	var qps, p95 []float64
+	for _, w := range last {
+		qps = append(qps, w.qps)
+		p95 = append(p95, w.p95)
+	}
+	return within(qps, s.tolerance) && within(p95, s.tolerance)
+}
+
+// within reports whether all values deviate from their mean within the
+// tolerance relative to the mean.
+func within(values []float64, tolerance float64) bool {
+	mean, _ := stats.Mean(values)
+	if mean == 0 {
+		return false
+	}
+	for _, v := range values {
+		if v < mean*(1-tolerance) || v > mean*(1+tolerance) {
+			return false
+		}
+	}
+	return true
+}
+
+// recorders returns operations started after steady state, and whether steady
+// state is reached.
+func (s *steadyState) recorders() (read, write *Recorder, reached bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &s.read, &s.write, !s.at.IsZero()
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// StreamStats are results of streaming reads, whose first chunk arrives
+// before the stream is drained.
+type StreamStats struct {
+	// FirstByte is latency until the first chunk arrives. reads of empty
+	// streams aren't recorded.
+	FirstByte Recorder
+	// Complete is latency until the stream is drained.
+	Complete Recorder
+}
+
+// Start starts timing a streaming read.
+func (s *StreamStats) Start() *StreamTimer {
+	return &StreamTimer{stats: s, start: time.Now()}
+}
+
+// StreamTimer times a streaming read. a nil timer does nothing, for reads
+// not timed.
+type StreamTimer struct {
+	stats *StreamStats
+	start time.Time
+	once  sync.Once
+}
+
+// Chunk marks a chunk arrived. only the first call takes effect.
+func (t *StreamTimer) Chunk() {
+	if t == nil {
+		return
+	}
+	t.once.Do(func() {
+		t.stats.FirstByte.Record(true, time.Since(t.start))
+	})
+}
+
+// Done records the read drained the stream with err.
+func (t *StreamTimer) Done(err error) {
+	if t == nil {
+		return
+	}
+	t.stats.Complete.Record(err == nil, time.Since(t.start))
+}
+
+// Reader returns r which marks a chunk arrived on the first read of any
+// bytes.
+func (t *StreamTimer) Reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &streamReader{r: r, t: t}
+}
+
+type streamReader struct {
+	r io.Reader
+	t *StreamTimer
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.t.Chunk()
+	}
+	return n, err
+}
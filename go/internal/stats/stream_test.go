@@ -0,0 +1,92 @@
+package stats
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowReader returns chunks after a delay on each read.
+type slowReader struct {
+	chunks []string
+	delay  time.Duration
+	err    error
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	if len(r.chunks) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestStreamTimer(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	var s StreamStats
+	timer := s.Start()
+	b, err := ioutil.ReadAll(timer.Reader(&slowReader{chunks: []string{"a", "b", "c"}, delay: delay}))
+	timer.Done(err)
+	if err != nil || string(b) != "abc" {
+		t.Fatalf("ReadAll() = %q, %v", b, err)
+	}
+	if s.FirstByte.Tries != 1 || s.Complete.Tries != 1 || s.Complete.Ok != 1 {
+		t.Fatalf("first byte tries = %d, complete ok / tries = %d / %d, want 1, 1 / 1", s.FirstByte.Tries, s.Complete.Ok, s.Complete.Tries)
+	}
+	// the first chunk arrives after a delay, and the stream is drained after
+	// 3 chunks and EOF.
+	var (
+		first    = s.FirstByte.Percentile(50)
+		complete = s.Complete.Percentile(50)
+	)
+	if first < delay {
+		t.Errorf("first byte = %v, want at least %v", first, delay)
+	}
+	if complete < 4*delay || complete-first < 3*delay {
+		t.Errorf("complete = %v, want at least %v and %v after the first byte", complete, 4*delay, 3*delay)
+	}
+}
+
+func TestStreamTimerEmpty(t *testing.T) {
+	var s StreamStats
+	timer := s.Start()
+	_, err := ioutil.ReadAll(timer.Reader(strings.NewReader("")))
+	timer.Done(err)
+	if s.FirstByte.Tries != 0 {
+		t.Errorf("first byte tries = %d, want 0 for an empty stream", s.FirstByte.Tries)
+	}
+	if s.Complete.Tries != 1 || s.Complete.Ok != 1 {
+		t.Errorf("complete ok / tries = %d / %d, want 1 / 1", s.Complete.Ok, s.Complete.Tries)
+	}
+}
+
+func TestStreamTimerError(t *testing.T) {
+	var s StreamStats
+	timer := s.Start()
+	_, err := ioutil.ReadAll(timer.Reader(&slowReader{chunks: []string{"a"}, err: errors.New("reset")}))
+	timer.Done(err)
+	if s.FirstByte.Tries != 1 || s.FirstByte.Ok != 1 {
+		t.Errorf("first byte ok / tries = %d / %d, want 1 / 1", s.FirstByte.Ok, s.FirstByte.Tries)
+	}
+	if s.Complete.Tries != 1 || s.Complete.Ok != 0 {
+		t.Errorf("complete ok / tries = %d / %d, want 0 / 1 for a failed stream", s.Complete.Ok, s.Complete.Tries)
+	}
+}
+
+func TestStreamTimerNil(t *testing.T) {
+	var timer *StreamTimer
+	r := strings.NewReader("abc")
+	if got := timer.Reader(r); got != io.Reader(r) {
+		t.Errorf("Reader() of nil timer = %v, want r as is", got)
+	}
+	timer.Chunk()
+	timer.Done(nil)
+}
@@ -0,0 +1,73 @@
+package stats
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+)
+
+// exitSummary is a minimal result of a run written to -exit_summary for
+// orchestration. latencies are in seconds.
+type exitSummary struct {
+	Passed    bool    `json:"passed"`
+	TotalOps  int     `json:"total_ops"`
+	ErrorRate float64 `json:"error_rate"`
+	ReadP99   float64 `json:"read_p99"`
+	WriteP99  float64 `json:"write_p99"`
+	// Steady is whether steady state is reached under -steady_state.
+	Steady bool `json:"steady"`
+
+	// failed is the number of failed operations, to merge error rates.
+	failed int
+}
+
+// newExitSummary returns the summary of read and write. the run is passed
+// unless it's aborted or the error rate is over -max_error_rate. under
+// -steady_state, only operations started after steady state are summarized,
+// and the run isn't passed if steady state isn't reached.
+func (s *Stats) newExitSummary(read, write *Recorder, aborted bool) exitSummary {
+	steady := true
+	if s.steady != nil {
+		read, write, steady = s.steady.recorders()
+	}
+	sum := exitSummary{
+		Steady:   steady,
+		TotalOps: read.Tries + write.Tries,
+		ReadP99:  read.Percentile(99).Seconds(),
+		WriteP99: write.Percentile(99).Seconds(),
+	}
+	sum.failed = sum.TotalOps - read.Ok - write.Ok
+	if sum.TotalOps > 0 {
+		sum.ErrorRate = float64(sum.failed) / float64(sum.TotalOps)
+	}
+	sum.Passed = !aborted && steady && sum.ErrorRate <= s.Config.MaxErrorRate
+	return sum
+}
+
+// mergeExitSummaries returns the summary of runs of -runs, -workload_script or
+// -interleave. the runs are passed if every run is passed, and the p99s are
+// the worst among the runs.
+func mergeExitSummaries(sums []exitSummary) exitSummary {
+	merged := exitSummary{Passed: true, Steady: true}
+	for _, sum := range sums {
+		merged.Passed = merged.Passed && sum.Passed
+		merged.Steady = merged.Steady && sum.Steady
+		merged.TotalOps += sum.TotalOps
+		merged.failed += sum.failed
+		merged.ReadP99 = math.Max(merged.ReadP99, sum.ReadP99)
+		merged.WriteP99 = math.Max(merged.WriteP99, sum.WriteP99)
+	}
+	if merged.TotalOps > 0 {
+		merged.ErrorRate = float64(merged.failed) / float64(merged.TotalOps)
+	}
+	return merged
+}
+
+// writeExitSummary writes sum to -exit_summary.
+func (s *Stats) writeExitSummary(sum exitSummary) error {
+	b, err := json.Marshal(sum)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.Config.ExitSummary, append(b, '\n'), 0644)
+}
@@ -0,0 +1,71 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func readExitSummary(t *testing.T, path string) exitSummary {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum exitSummary
+	if err := json.Unmarshal(b, &sum); err != nil {
+		t.Fatalf("exit summary %q: %v", b, err)
+	}
+	return sum
+}
+
+func TestExitSummary(t *testing.T) {
+	dir := writeTestFiles(t, nil)
+	conf := NewConfig()
+	conf.RunFor = 100 * time.Millisecond
+	conf.ReqCount = 2
+	conf.ExitSummary = filepath.Join(dir, "summary.json")
+	conf.MaxErrorRate = 0.2
+	// every 10th operation fails.
+	var n int64
+	op := func(ctx context.Context, id int) error {
+		time.Sleep(time.Millisecond)
+		if atomic.AddInt64(&n, 1)%10 == 0 {
+			return errors.New("failed")
+		}
+		return nil
+	}
+	read, write, err := NewStats(conf).Start(op, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := readExitSummary(t, conf.ExitSummary)
+	tries := read.Tries + write.Tries
+	if sum.TotalOps != tries || sum.TotalOps == 0 {
+		t.Errorf("total_ops = %d, want %d", sum.TotalOps, tries)
+	}
+	if want := float64(tries-read.Ok-write.Ok) / float64(tries); math.Abs(sum.ErrorRate-want) > 1e-9 || sum.ErrorRate == 0 {
+		t.Errorf("error_rate = %v, want %v", sum.ErrorRate, want)
+	}
+	if sum.ReadP99 != read.Percentile(99).Seconds() || sum.WriteP99 != write.Percentile(99).Seconds() || sum.ReadP99 < 0.001 {
+		t.Errorf("p99 = %v / %v, want %v / %v in seconds", sum.ReadP99, sum.WriteP99, read.Percentile(99).Seconds(), write.Percentile(99).Seconds())
+	}
+	if !sum.Passed {
+		t.Errorf("passed = false, want true under -max_error_rate %v", conf.MaxErrorRate)
+	}
+
+	conf.MaxErrorRate = 0.01
+	if _, _, err := NewStats(conf).Start(op, op); err != nil {
+		t.Fatal(err)
+	}
+	if sum := readExitSummary(t, conf.ExitSummary); sum.Passed {
+		t.Errorf("passed = true with error_rate %v, want false over -max_error_rate %v", sum.ErrorRate, conf.MaxErrorRate)
+	}
+}
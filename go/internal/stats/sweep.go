@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxSweepFailures is the number of keys listed in the report of the
+// integrity sweep.
+const maxSweepFailures = 10
+
+// keySet is a set of keys written during a run for -integrity_sweep.
+type keySet struct {
+	mu   sync.Mutex
+	keys map[int]struct{}
+}
+
+func newKeySet() *keySet {
+	return &keySet{keys: make(map[int]struct{})}
+}
+
+func (k *keySet) add(id int) {
+	k.mu.Lock()
+	k.keys[id] = struct{}{}
+	k.mu.Unlock()
+}
+
+func (k *keySet) sorted() []int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	ids := make([]int, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// sweepResult is the result of the integrity sweep.
+type sweepResult struct {
+	keys    int
+	missing []int
+	failed  []int
+}
+
+func (r *sweepResult) String() string {
+	return fmt.Sprintf(
+		"keys: %d\n"+
+			"missing: %d%s\n"+
+			"failed: %d%s\n",
+		r.keys,
+		len(r.missing), listKeys(r.missing),
+		len(r.failed), listKeys(r.failed),
+	)
+}
+
+func listKeys(ids []int) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	sort.Ints(ids)
+	var s []string
+	for i, id := range ids {
+		if i == maxSweepFailures {
+			s = append(s, "...")
+			break
+		}
+		s = append(s, fmt.Sprint(id))
+	}
+	return " (" + strings.Join(s, ", ") + ")"
+}
+
+// sweep reads every key written during the run by Stats.Sweep, or readFunc
+// if it's nil, on -req_count goroutines. a key is missing if the read
+// returns ErrMissing, and failed on any other error.
+func (s *Stats) sweep(ctx context.Context, readFunc StatsFunc) *sweepResult {
+	read := s.Sweep
+	if read == nil {
+		read = readFunc
+	}
+	var (
+		ids    = s.written.sorted()
+		result = &sweepResult{keys: len(ids)}
+		ch     = make(chan int)
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+	)
+	for i := 0; i < s.Config.ReqCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ch {
+				err := read(ctx, id)
+				if err == nil {
+					continue
+				}
+				mu.Lock()
+				if errors.Is(err, ErrMissing) {
+					result.missing = append(result.missing, id)
+				} else {
+					log.Printf("Error sweeping key %d: %v", id, err)
+					result.failed = append(result.failed, id)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, id := range ids {
+		ch <- id
+	}
+	close(ch)
+	wg.Wait()
+	return result
+}
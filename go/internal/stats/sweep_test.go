@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	conf := NewConfig()
+	conf.RunFor = 50 * time.Millisecond
+	conf.ReqCount = 4
+	conf.Keys = 10
+	conf.IntegritySweep = true
+
+	var (
+		mu    sync.Mutex
+		store = make(map[int]bool)
+	)
+	writeFunc := func(ctx context.Context, id int) error {
+		mu.Lock()
+		store[id] = true
+		mu.Unlock()
+		return nil
+	}
+	readFunc := func(ctx context.Context, id int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if id == 7 {
+			return errors.New("unavailable")
+		}
+		if !store[id] {
+			return ErrMissing
+		}
+		return nil
+	}
+	s := NewStats(conf)
+	if _, _, err := s.Start(readFunc, writeFunc); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	written := len(store)
+	if !store[3] || !store[7] {
+		mu.Unlock()
+		t.Fatalf("written keys = %v, want 3 and 7 written", store)
+	}
+	// the key is deleted after the run.
+	delete(store, 3)
+	mu.Unlock()
+
+	result := s.sweep(context.Background(), readFunc)
+	if result.keys != written {
+		t.Errorf("swept %d keys, want %d written", result.keys, written)
+	}
+	if !reflect.DeepEqual(result.missing, []int{3}) {
+		t.Errorf("missing keys = %v, want the deleted key [3]", result.missing)
+	}
+	if !reflect.DeepEqual(result.failed, []int{7}) {
+		t.Errorf("failed keys = %v, want [7]", result.failed)
+	}
+}
@@ -0,0 +1,133 @@
+package stats
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// throttleDecrease is the factor the rate is multiplied by on throttling.
+	throttleDecrease = 0.5
+	// throttleIncrease is the ratio of the target rate added to the rate
+	// every throttleInterval without throttling.
+	throttleIncrease = 0.05
+	// throttleInterval is the interval to increase the rate, and also the
+	// cooldown not to decrease the rate repeatedly by a burst of throttling.
+	throttleInterval = time.Second
+)
+
+// IsThrottleStatus reports whether err is a gRPC status telling the client to
+// back off. it's used by -throttle_backoff unless Stats.Throttled is set.
+func IsThrottleStatus(err error) bool {
+	st, ok := status.FromError(err)
+	return ok && st.Code() == codes.ResourceExhausted
+}
+
+// throttle adjusts the rate of -target_qps by AIMD on throttling; the rate is
+// decreased multiplicatively on throttling, and increased additively up to
+// the target while throttling doesn't occur.
+type throttle struct {
+	mu           sync.Mutex
+	target       float64
+	rate         float64
+	minRate      float64
+	throttled    int
+	decreases    int
+	increases    int
+	lastDecrease time.Time
+}
+
+func newThrottle(target int) *throttle {
+	return &throttle{
+		target:  float64(target),
+		rate:    float64(target),
+		minRate: float64(target),
+	}
+}
+
+// interval returns the interval of releasing tokens at the current rate.
+func (t *throttle) interval() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Duration(float64(time.Second) / t.rate)
+}
+
+func (t *throttle) onThrottle() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.throttled++
+	if time.Since(t.lastDecrease) < throttleInterval {
+		return
+	}
+	t.rate *= throttleDecrease
+	if t.rate < 1 {
+		t.rate = 1
+	}
+	if t.rate < t.minRate {
+		t.minRate = t.rate
+	}
+	t.decreases++
+	t.lastDecrease = time.Now()
+}
+
+func (t *throttle) increase() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rate >= t.target || time.Since(t.lastDecrease) < throttleInterval {
+		return
+	}
+	t.rate += t.target * throttleIncrease
+	if t.rate > t.target {
+		t.rate = t.target
+	}
+	t.increases++
+}
+
+// start increases the rate periodically until done is closed.
+func (t *throttle) start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(throttleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				t.increase()
+			}
+		}
+	}()
+}
+
+func (t *throttle) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return fmt.Sprintf(
+		"throttled ops: %d\n"+
+			"rate decreases: %d\n"+
+			"rate increases: %d\n"+
+			"min rate: %.1f qps\n"+
+			"final rate: %.1f qps (target %.0f qps)\n",
+		t.throttled, t.decreases, t.increases, t.minRate, t.rate, t.target,
+	)
+}
+
+// isThrottle reports whether err is throttling of the backend.
+func (s *Stats) isThrottle(err error) bool {
+	if s.Throttled != nil {
+		return s.Throttled(err)
+	}
+	return IsThrottleStatus(err)
+}
+
+// backOff slows down -target_qps if err is throttling under
+// -throttle_backoff.
+func (s *Stats) backOff(err error) {
+	if s.throttle != nil && s.isThrottle(err) {
+		s.throttle.onThrottle()
+	}
+}
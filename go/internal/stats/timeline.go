@@ -0,0 +1,18 @@
+package stats
+
+import "time"
+
+// timeline returns t as a point on the timeline of the run, which records
+// start times of operations. latencies are always measured by monotonic
+// readings of time.Now, so only the timeline is affected by -timeline_clock.
+//
+// on the wall clock, t is used as is and a step of the clock such as by NTP
+// shifts the following points. on the monotonic clock, t is the wall time of
+// the run start plus the monotonic time elapsed since then, so points keep
+// their distances across steps of the clock.
+func (s *Stats) timeline(t time.Time) time.Time {
+	if s.Config.TimelineClock != "monotonic" {
+		return t
+	}
+	return s.started.Add(t.Sub(s.started))
+}
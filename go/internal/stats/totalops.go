@@ -0,0 +1,63 @@
+package stats
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minMeaningfulRun is the duration of a run of -total_ops below which the
+// result is warned to be noisy.
+const minMeaningfulRun = time.Second
+
+// takeOp takes a slot of -total_ops for an operation, and returns false if
+// the slots are exhausted or done is closed. under -min_run_duration, the
+// n-th operation waits until n/total_ops of the duration has elapsed, so the
+// run lasts at least the duration. the returned time is the slot of the
+// operation under -min_run_duration, or zero otherwise.
+func (s *Stats) takeOp(done <-chan struct{}) (time.Time, bool) {
+	total := s.Config.TotalOps
+	if total == 0 {
+		return time.Time{}, true
+	}
+	n := atomic.AddInt64(&s.issued, 1)
+	if n > int64(total) {
+		return time.Time{}, false
+	}
+	min := s.Config.MinRunDuration
+	if min == 0 {
+		return time.Time{}, true
+	}
+	at := s.started.Add(time.Duration(float64(min) * float64(n) / float64(total)))
+	if wait := time.Until(at); wait > 0 {
+		select {
+		case <-done:
+			return time.Time{}, false
+		case <-time.After(wait):
+		}
+	}
+	return at, true
+}
+
+// opsDone returns a channel closed when workers of wg finish -total_ops, or
+// nil unless -total_ops is set.
+func (s *Stats) opsDone(wg *sync.WaitGroup) <-chan struct{} {
+	if s.Config.TotalOps == 0 {
+		return nil
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	return finished
+}
+
+// warnShortRun warns if -total_ops finished too quickly for the result to be
+// meaningful.
+func (s *Stats) warnShortRun(elapsed time.Duration) {
+	if s.Config.MinRunDuration == 0 && elapsed < minMeaningfulRun {
+		log.Printf("Warning: %d ops finished in %v, which is too short to be meaningful; set -min_run_duration to spread them", s.Config.TotalOps, elapsed)
+	}
+}
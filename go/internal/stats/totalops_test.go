@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMinRunDuration(t *testing.T) {
+	tests := []struct {
+		name        string
+		minDuration time.Duration
+	}{
+		{name: "total ops only"},
+		{name: "min run duration", minDuration: 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.RunFor = time.Hour
+			conf.ReqCount = 4
+			conf.TotalOps = 10
+			conf.MinRunDuration = tt.minDuration
+			op := func(ctx context.Context, id int) error { return nil }
+
+			start := time.Now()
+			read, write, err := NewStats(conf).Start(op, op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+			if n := read.Tries + write.Tries; n != conf.TotalOps {
+				t.Errorf("ran %d ops, want %d", n, conf.TotalOps)
+			}
+			if elapsed < tt.minDuration {
+				t.Errorf("run took %v, want at least %v", elapsed, tt.minDuration)
+			}
+			if tt.minDuration == 0 && elapsed > 100*time.Millisecond {
+				t.Errorf("run took %v, want it done as fast as the ops", elapsed)
+			}
+		})
+	}
+}
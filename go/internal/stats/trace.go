@@ -0,0 +1,23 @@
+package stats
+
+import (
+	"os"
+	"runtime/trace"
+)
+
+// startTrace starts the execution trace written to path. the returned func
+// stops the trace and flushes it to the file.
+func startTrace(path string) (func(), error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := trace.Start(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		trace.Stop()
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,34 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStartTrace(t *testing.T) {
+	dir := writeTestFiles(t, nil)
+	conf := NewConfig()
+	conf.RunFor = 50 * time.Millisecond
+	conf.ReqCount = 2
+	conf.Trace = filepath.Join(dir, "trace.out")
+	op := func(ctx context.Context, id int) error { return nil }
+	if _, _, err := NewStats(conf).Start(op, op); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(conf.Trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) < 16 {
+		t.Fatalf("trace is %d bytes, want a header at least", len(b))
+	}
+	// a trace begins with a header such as "go 1.21 trace\x00\x00\x00".
+	if !bytes.HasPrefix(b, []byte("go 1.")) || !bytes.Contains(b[:16], []byte(" trace\x00")) {
+		t.Errorf("trace of %d bytes begins with %q, want a trace header", len(b), b[:16])
+	}
+}
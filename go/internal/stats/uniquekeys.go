@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// keyTracker tracks distinct keys of operations by op, to validate the
+// distribution of keys against the key space.
+type keyTracker struct {
+	mu   sync.Mutex
+	keys map[string]map[int]struct{}
+	ops  map[string]int
+}
+
+func newKeyTracker() *keyTracker {
+	return &keyTracker{
+		keys: make(map[string]map[int]struct{}),
+		ops:  make(map[string]int),
+	}
+}
+
+func (k *keyTracker) add(op string, id int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	keys, ok := k.keys[op]
+	if !ok {
+		keys = make(map[int]struct{})
+		k.keys[op] = keys
+	}
+	keys[id] = struct{}{}
+	k.ops[op]++
+}
+
+// unique returns the number of distinct keys and operations of op.
+func (k *keyTracker) unique(op string) (keys, ops int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.keys[op]), k.ops[op]
+}
+
+// String returns distinct keys of each op with the repeat ratio, which is the
+// fraction of operations on a key already operated.
+func (k *keyTracker) String() string {
+	k.mu.Lock()
+	ops := make([]string, 0, len(k.ops))
+	for op := range k.ops {
+		ops = append(ops, op)
+	}
+	k.mu.Unlock()
+	sort.Strings(ops)
+
+	var buf bytes.Buffer
+	for _, op := range ops {
+		keys, n := k.unique(op)
+		fmt.Fprintf(&buf, "%s: %d unique keys of %d ops (repeat ratio %.3f)\n", op, keys, n, 1-float64(keys)/float64(n))
+	}
+	return buf.String()
+}
@@ -0,0 +1,33 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+)
+
+// utilization returns the fraction of time workers spent running operations
+// out of their lifetime. it's near 1 if workers are always busy, which means
+// the harness can't offer more load, and low if they mostly wait for tokens
+// of -target_qps or think time. workers abandoned by -drain_timeout are
+// counted until now without their abandoned operations.
+func utilization(workers []*worker, started, now time.Time) (busy, total time.Duration) {
+	for _, w := range workers {
+		ended := w.ended
+		if ended.IsZero() {
+			ended = now
+		}
+		busy += w.busy
+		total += ended.Sub(started)
+	}
+	return busy, total
+}
+
+// utilizationReport returns the utilization of workers.
+func utilizationReport(workers []*worker, started time.Time) string {
+	busy, total := utilization(workers, started, time.Now())
+	if total <= 0 {
+		return "no workers"
+	}
+	return fmt.Sprintf("%.1f%% (busy %v of %v of %d workers)",
+		float64(busy)/float64(total)*100, busy.Round(time.Millisecond), total.Round(time.Millisecond), len(workers))
+}
@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// validations counts operations failed by Stats.Validate by category.
+type validations struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (v *validations) add(err error) {
+	category := "validation"
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		category = verr.Category
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.counts == nil {
+		v.counts = make(map[string]int)
+	}
+	v.counts[category]++
+}
+
+func (v *validations) String() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	categories := make([]string, 0, len(v.counts))
+	for category := range v.counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	var buf bytes.Buffer
+	for _, category := range categories {
+		fmt.Fprintf(&buf, "%s: %d\n", category, v.counts[category])
+	}
+	return buf.String()
+}
+
+// validate runs Stats.Validate on the result of an operation, and returns
+// false if it fails the operation.
+func (s *Stats) validate(op string, id int, err error) bool {
+	if s.Validate == nil {
+		return true
+	}
+	verr := s.Validate(op, id, err)
+	if verr == nil {
+		return true
+	}
+	log.Printf("Error validating %s: %v", op, verr)
+	s.invalid.add(verr)
+	return false
+}
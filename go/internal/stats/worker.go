@@ -0,0 +1,60 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/montanaflynn/stats"
+)
+
+// workerReport returns the spread of p95 across workers, flagging workers
+// whose p95 is more than factor times the p95 of the median worker. a worker
+// with no operations is left out.
+func workerReport(workers []*Recorder, factor float64) string {
+	var (
+		p95s    = make([]float64, len(workers))
+		present []float64
+	)
+	for i, w := range workers {
+		if len(w.durations) == 0 {
+			continue
+		}
+		p95s[i] = float64(w.Percentile(95))
+		present = append(present, p95s[i])
+	}
+	if len(present) == 0 {
+		return "no operations\n"
+	}
+
+	var (
+		b         strings.Builder
+		min, _    = stats.Min(present)
+		max, _    = stats.Max(present)
+		median, _ = stats.Median(present)
+	)
+	fmt.Fprintf(&b, "p95 min: %v\n", time.Duration(min))
+	fmt.Fprintf(&b, "p95 median: %v\n", time.Duration(median))
+	fmt.Fprintf(&b, "p95 max: %v\n", time.Duration(max))
+	for _, i := range outlierWorkers(p95s, median, factor) {
+		fmt.Fprintf(&b, "outlier worker %d: p95 %v (%.1fx median, %d ops)\n",
+			i, time.Duration(p95s[i]), p95s[i]/median, workers[i].Tries)
+	}
+	return b.String()
+}
+
+// outlierWorkers returns indices of p95s more than factor times median, in
+// descending order of p95.
+func outlierWorkers(p95s []float64, median, factor float64) []int {
+	var outliers []int
+	for i, p := range p95s {
+		if median > 0 && p > median*factor {
+			outliers = append(outliers, i)
+		}
+	}
+	sort.Slice(outliers, func(i, j int) bool {
+		return p95s[outliers[i]] > p95s[outliers[j]]
+	})
+	return outliers
+}
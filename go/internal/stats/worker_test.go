@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkerReportOutlier(t *testing.T) {
+	workers := make([]*Recorder, 5)
+	for i := range workers {
+		workers[i] = new(Recorder)
+		d := time.Duration(10+i) * time.Millisecond
+		if i == 3 {
+			// a worker on a bad connection.
+			d = 50 * time.Millisecond
+		}
+		for j := 0; j < 20; j++ {
+			workers[i].Record(true, d)
+		}
+	}
+	workers = append(workers, new(Recorder))
+
+	report := workerReport(workers, 2)
+	if !strings.Contains(report, "outlier worker 3: p95 50ms") {
+		t.Errorf("report doesn't flag the slow worker:\n%s", report)
+	}
+	if n := strings.Count(report, "outlier worker"); n != 1 {
+		t.Errorf("report flags %d workers, want 1:\n%s", n, report)
+	}
+	for _, line := range []string{"p95 min: 10ms", "p95 median: 12ms", "p95 max: 50ms"} {
+		if !strings.Contains(report, line) {
+			t.Errorf("report doesn't have %q:\n%s", line, report)
+		}
+	}
+}
+
+func TestPerWorker(t *testing.T) {
+	conf := NewConfig()
+	conf.RunFor = 50 * time.Millisecond
+	conf.ReqCount = 3
+	conf.PerWorker = true
+	op := func(ctx context.Context, id int) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	s := NewStats(conf)
+	read, write, err := s.Start(op, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Workers) != conf.ReqCount {
+		t.Fatalf("%d workers are recorded, want %d", len(s.Workers), conf.ReqCount)
+	}
+	var tries int
+	for i, w := range s.Workers {
+		if w.Tries == 0 {
+			t.Errorf("worker %d has no operations", i)
+		}
+		tries += w.Tries
+	}
+	if tries != read.Tries+write.Tries {
+		t.Errorf("workers have %d operations, want %d", tries, read.Tries+write.Tries)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+
+	"github.com/ryutah/gcp-sample/go/internal/history"
+	"github.com/ryutah/gcp-sample/go/internal/payload"
+	"github.com/ryutah/gcp-sample/go/internal/stats"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+type config struct {
+	Bucket       string `validate:"required"`
+	ObjectPrefix string `validate:"required"`
+	ContentType  string `validate:"required"`
+	Overwrite    bool
+
+	VerifyCleanup bool
+}
+
+func (c *config) registerFlags() {
+	flag.StringVar(&c.Bucket, "bucket", "", "name of bucket to use")
+	flag.StringVar(&c.ObjectPrefix, "object_prefix", "scratch/", "prefix of object names; objects written by the test are deleted after the test")
+	flag.StringVar(&c.ContentType, "content_type", "application/octet-stream", "content type of written objects")
+	flag.BoolVar(&c.Overwrite, "overwrite", true, "overwrite an object per key; false to write uniquely named objects on every write")
+	flag.BoolVar(&c.VerifyCleanup, "verify_cleanup", false, "verify the objects are deleted after the test, and exit non-zero if not")
+}
+
+func (c config) validate() error {
+	return validator.New().Struct(c)
+}
+
+func initialize() (*config, *stats.Stats, *payload.Config, error) {
+	var (
+		conf  = new(config)
+		sConf = stats.NewConfig()
+		pConf = payload.NewConfig()
+	)
+	sConf.Backend = "storage"
+	conf.registerFlags()
+	sConf.RegisterFlags()
+	pConf.RegisterFlags()
+	if err := sConf.ParseFlags(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := conf.validate(); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := sConf.Validate(); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := pConf.Validate(); err != nil {
+		return nil, nil, nil, err
+	}
+	return conf, stats.NewStats(sConf), pConf, nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the test. errors are returned instead of exiting, so deferred
+// cleanup runs even if the test fails.
+func run() (err error) {
+	ctx := context.Background()
+	conf, sts, pConf, err := initialize()
+	if err != nil {
+		return err
+	}
+
+	if sts.Config.Estimate {
+		p, err := sts.Config.Project(pConf.RowSize)
+		if err != nil {
+			return err
+		}
+		fmt.Print(p)
+		return nil
+	}
+	var (
+		codec = payload.NewCodec(pConf)
+		gen   = payload.NewGenerator(pConf)
+	)
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var (
+		bucket = client.Bucket(conf.Bucket)
+		names  = newObjectNamer(conf.ObjectPrefix, conf.Overwrite)
+	)
+	defer func() {
+		if cerr := cleanup(ctx, bucket, names.written(), conf.VerifyCleanup); cerr != nil {
+			log.Printf("Error cleaning up: %v", cerr)
+			// the error of the test takes precedence.
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	var (
+		// reads are also recorded until the first byte and until the object is
+		// read entirely.
+		stream   stats.StreamStats
+		readFunc = func(ctx context.Context, id int) error {
+			name, ok := names.latest(id)
+			if !ok {
+				// reading nothing isn't recorded, not to skew latencies.
+				return stats.ErrSkipped
+			}
+			return read(ctx, bucket, codec, name, stream.Start())
+		}
+		writeFunc = func(ctx context.Context, id int) error {
+			return write(ctx, bucket, codec, gen, id, names.next(id), conf.ContentType)
+		}
+	)
+
+	sts.Heartbeat = func(ctx context.Context) error {
+		_, err := bucket.Attrs(ctx)
+		return err
+	}
+	sts.Fatal = fatalError
+	sts.Throttled = throttleError
+	sts.AppendHistory = history.Append
+	sts.Sweep = func(ctx context.Context, id int) error {
+		name, ok := names.latest(id)
+		if !ok {
+			return stats.ErrMissing
+		}
+		if _, err := bucket.Object(name).Attrs(ctx); err == storage.ErrObjectNotExist {
+			return stats.ErrMissing
+		} else if err != nil {
+			return err
+		}
+		return read(ctx, bucket, codec, name, nil)
+	}
+
+	if sts.Config.MultiRun() {
+		runs, err := sts.StartRuns(readFunc, writeFunc)
+		if err != nil {
+			return err
+		}
+		log.Printf("Comparison:\n%v", stats.Compare(runs))
+		if v := stats.Verdict(runs); v != "" {
+			log.Printf("Verdict:\n%v", v)
+		}
+		if err := sts.WriteManifest(); err != nil {
+			log.Printf("Error writing manifest: %v", err)
+		}
+		return nil
+	}
+
+	readRec, writeRec, err := sts.Start(readFunc, writeFunc)
+	if err != nil {
+		return err
+	}
+	log.Printf("Reads (%d ok / %d tries):\n%v", readRec.Ok, readRec.Tries, readRec.Aggregate())
+	log.Printf("Writes (%d ok / %d tries):\n%v", writeRec.Ok, writeRec.Tries, writeRec.Aggregate())
+	log.Printf("Read first byte (%d):\n%v", stream.FirstByte.Tries, stream.FirstByte.Aggregate())
+	log.Printf("Read complete (%d ok / %d tries):\n%v", stream.Complete.Ok, stream.Complete.Tries, stream.Complete.Aggregate())
+	if sts.GC != nil {
+		log.Printf("GC:\n%v", sts.GC)
+	}
+	if codec.Enabled() {
+		log.Printf("Compress (%d ok / %d tries):\n%v", codec.Compress.Ok, codec.Compress.Tries, codec.Compress.Aggregate())
+		log.Printf("Decompress (%d ok / %d tries):\n%v", codec.Decompress.Ok, codec.Decompress.Tries, codec.Decompress.Aggregate())
+	}
+	if err := sts.WriteManifest(); err != nil {
+		log.Printf("Error writing manifest: %v", err)
+	}
+	return nil
+}
+
+// objectNamer names objects written for keys. on overwrite, an object per key
+// is overwritten, otherwise every write creates an uniquely named object.
+type objectNamer struct {
+	prefix    string
+	overwrite bool
+	seq       int64
+
+	mu    sync.Mutex
+	names map[int]string
+	// all are names of all objects written, which are deleted after the test.
+	all map[string]bool
+}
+
+func newObjectNamer(prefix string, overwrite bool) *objectNamer {
+	return &objectNamer{
+		prefix:    prefix,
+		overwrite: overwrite,
+		names:     make(map[int]string),
+		all:       make(map[string]bool),
+	}
+}
+
+// next returns the name of the object to write for id.
+func (o *objectNamer) next(id int) string {
+	name := fmt.Sprintf("%s%d", o.prefix, id)
+	if !o.overwrite {
+		name = fmt.Sprintf("%s-%d", name, atomic.AddInt64(&o.seq, 1))
+	}
+	o.mu.Lock()
+	o.names[id] = name
+	o.all[name] = true
+	o.mu.Unlock()
+	return name
+}
+
+// written returns the sorted names of all objects written, including failed
+// writes which may have created the object.
+func (o *objectNamer) written() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	names := make([]string, 0, len(o.all))
+	for name := range o.all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// latest returns the name of the object last written for id.
+func (o *objectNamer) latest(id int) (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	name, ok := o.names[id]
+	return name, ok
+}
+
+// fatalError reports whether err won't be resolved by retrying, such as a
+// missing bucket or denied credentials.
+func fatalError(err error) bool {
+	if err == storage.ErrBucketNotExist {
+		return true
+	}
+	gerr, ok := err.(*googleapi.Error)
+	return ok && (gerr.Code == 401 || gerr.Code == 403)
+}
+
+// throttleError reports whether err tells the client to back off.
+func throttleError(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	return ok && (gerr.Code == 429 || gerr.Code == 503)
+}
+
+func write(ctx context.Context, bucket *storage.BucketHandle, codec *payload.Codec, gen *payload.Generator, id int, name, contentType string) error {
+	// write 1KB object.
+	buf := gen.Get(id)
+	defer gen.Put(buf)
+	value, err := codec.Encode(ctx, buf)
+	if err != nil {
+		return err
+	}
+	w := bucket.Object(name).NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := w.Write(value); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// read reads the object of name. the read is timed by timer unless it's nil.
+func read(ctx context.Context, bucket *storage.BucketHandle, codec *payload.Codec, name string, timer *stats.StreamTimer) error {
+	r, err := bucket.Object(name).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil
+	} else if err != nil {
+		timer.Done(err)
+		return err
+	}
+	defer r.Close()
+
+	value, err := ioutil.ReadAll(timer.Reader(r))
+	timer.Done(err)
+	if err != nil {
+		return err
+	}
+	_, err = codec.Decode(ctx, value)
+	return err
+}
+
+// deleteObjects deletes objects of names. objects which don't exist, such as
+// by failed writes, are ignored.
+func deleteObjects(ctx context.Context, bucket *storage.BucketHandle, names []string) error {
+	for _, name := range names {
+		if err := bucket.Object(name).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanup deletes the objects of names written by the test, and verifies they
+// are deleted if verify is set. other objects under the prefix are left
+// untouched, since the prefix may be shared.
+func cleanup(ctx context.Context, bucket *storage.BucketHandle, names []string, verify bool) error {
+	err := deleteObjects(ctx, bucket, names)
+	if !verify {
+		return err
+	}
+	if err != nil {
+		log.Printf("Error deleting objects: %v", err)
+	}
+	for _, name := range names {
+		if _, verr := bucket.Object(name).Attrs(ctx); verr == nil {
+			return fmt.Errorf("verify cleanup: object %s is left behind", name)
+		} else if verr != storage.ErrObjectNotExist {
+			return fmt.Errorf("verify cleanup: %v", verr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestObjectNamerWritten(t *testing.T) {
+	tests := []struct {
+		overwrite  bool
+		want       []string
+		wantLatest string
+	}{
+		{overwrite: true, want: []string{"p/1", "p/2"}, wantLatest: "p/1"},
+		{overwrite: false, want: []string{"p/1-1", "p/1-3", "p/2-2"}, wantLatest: "p/1-3"},
+	}
+	for _, tt := range tests {
+		names := newObjectNamer("p/", tt.overwrite)
+		names.next(1)
+		names.next(2)
+		names.next(1)
+		if got := names.written(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("overwrite=%v: written() = %v, want %v", tt.overwrite, got, tt.want)
+		}
+		if got, ok := names.latest(1); !ok || got != tt.wantLatest {
+			t.Errorf("overwrite=%v: latest(1) = %v, %v, want %v", tt.overwrite, got, ok, tt.wantLatest)
+		}
+		if _, ok := names.latest(3); ok {
+			t.Errorf("overwrite=%v: latest(3) is found, want not written", tt.overwrite)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Command workload runs a workload registered by workloads.RegisterWorkload on
+// the shared harness. packages implementing workloads register them in init,
+// and are linked by blank imports of this file.
+//
+//	workload -workload=sleep -run_for=10s -- -latency=20ms
+//
+// flags after "--" are passed to the workload. summaries written by
+// -summary_file on multiple instances are merged by -merge:
+//
+//	workload -merge summary1.json summary2.json
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ryutah/gcp-sample/go/internal/history"
+	"github.com/ryutah/gcp-sample/go/internal/stats"
+	"github.com/ryutah/gcp-sample/go/workloads"
+	_ "github.com/ryutah/gcp-sample/go/workloads/sleep"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the workload. errors are returned instead of exiting, so the
+// workload is closed even if the run fails.
+func run() error {
+	var (
+		name  string
+		merge bool
+	)
+	sConf := stats.NewConfig()
+	sConf.RegisterFlags()
+	flag.StringVar(&name, "workload", "", "name of the workload to run; one of "+strings.Join(workloads.Names(), ", "))
+	flag.BoolVar(&merge, "merge", false, "merge summaries written by -summary_file given as arguments, and print the global summary instead of running a workload")
+	if err := sConf.ParseFlags(); err != nil {
+		return err
+	}
+	if merge {
+		if err := mergeSummaries(flag.Args()); err != nil {
+			return err
+		}
+		return nil
+	}
+	if sConf.Backend == "" {
+		sConf.Backend = name
+	}
+	if err := sConf.Validate(); err != nil {
+		return err
+	}
+
+	if sConf.Estimate {
+		// workloads have no rows, so bytes aren't projected.
+		p, err := sConf.Project(0)
+		if err != nil {
+			return err
+		}
+		fmt.Print(p)
+		return nil
+	}
+
+	w, err := workloads.New(name, flag.Args())
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	sts := stats.NewStats(sConf)
+	sts.AppendHistory = history.Append
+	if sts.Config.MultiRun() {
+		runs, err := sts.StartRuns(w.Read, w.Write)
+		if err != nil {
+			return err
+		}
+		log.Printf("Comparison:\n%v", stats.Compare(runs))
+		if v := stats.Verdict(runs); v != "" {
+			log.Printf("Verdict:\n%v", v)
+		}
+		if err := sts.WriteManifest(); err != nil {
+			log.Printf("Error writing manifest: %v", err)
+		}
+		return nil
+	}
+
+	read, write, err := sts.Start(w.Read, w.Write)
+	if err != nil {
+		return err
+	}
+	log.Printf("Reads (%d ok / %d tries):\n%v", read.Ok, read.Tries, read.Aggregate())
+	log.Printf("Writes (%d ok / %d tries):\n%v", write.Ok, write.Tries, write.Aggregate())
+	if sts.GC != nil {
+		log.Printf("GC:\n%v", sts.GC)
+	}
+	if err := sts.WriteManifest(); err != nil {
+		log.Printf("Error writing manifest: %v", err)
+	}
+	return nil
+}
+
+// mergeSummaries prints the global summary of summaries in paths.
+func mergeSummaries(paths []string) error {
+	if len(paths) == 0 {
+		return errors.New("-merge requires summary files")
+	}
+	var sums []*stats.Summary
+	for _, path := range paths {
+		sum, err := stats.ReadSummary(path)
+		if err != nil {
+			return err
+		}
+		sums = append(sums, sum)
+	}
+	fmt.Print(stats.MergeSummaries(sums...))
+	return nil
+}
@@ -0,0 +1,60 @@
+// Package sleep registers the sleep workload, which sleeps for random latency
+// instead of calling a backend, to try flags of the harness without any
+// backend. it's also an example of a workload registered from its own
+// package.
+package sleep
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"time"
+
+	"github.com/ryutah/gcp-sample/go/workloads"
+)
+
+func init() {
+	workloads.RegisterWorkload("sleep", New)
+}
+
+type sleepWorkload struct {
+	latency time.Duration
+	jitter  time.Duration
+}
+
+// New creates the sleep workload from its flags in args.
+func New(args []string) (workloads.Workload, error) {
+	w := new(sleepWorkload)
+	fs := flag.NewFlagSet("sleep", flag.ContinueOnError)
+	fs.DurationVar(&w.latency, "latency", 10*time.Millisecond, "latency of each operation")
+	fs.DurationVar(&w.jitter, "jitter", 5*time.Millisecond, "max random deviation added to -latency")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *sleepWorkload) sleep(ctx context.Context) error {
+	d := w.latency
+	if w.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*w.jitter))) - w.jitter
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func (w *sleepWorkload) Read(ctx context.Context, id int) error {
+	return w.sleep(ctx)
+}
+
+func (w *sleepWorkload) Write(ctx context.Context, id int) error {
+	return w.sleep(ctx)
+}
+
+func (w *sleepWorkload) Close() error {
+	return nil
+}
@@ -0,0 +1,31 @@
+package sleep
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ryutah/gcp-sample/go/workloads"
+)
+
+func TestRegistered(t *testing.T) {
+	if _, err := workloads.New("sleep", []string{"-latency=1ms"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	w, err := New([]string{"-latency=1h", "-jitter=0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := w.Read(ctx, 0); err != context.Canceled {
+		t.Errorf("Read() = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Read() took %v after the context is canceled", d)
+	}
+}
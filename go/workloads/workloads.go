@@ -0,0 +1,65 @@
+// Package workloads is the registry of workloads run on the shared harness
+// by the workload command. packages outside this repository implement
+// Workload and register it by RegisterWorkload in init, so a command linking
+// them by blank imports runs them without forking the harness.
+package workloads
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Workload is a backend benchmarked by the harness, which is registered by
+// RegisterWorkload to be selectable by name.
+type Workload interface {
+	Read(ctx context.Context, id int) error
+	Write(ctx context.Context, id int) error
+	Close() error
+}
+
+// Factory creates a workload from its own flags, which are usually parsed by
+// a flag.FlagSet of the workload.
+type Factory func(args []string) (Workload, error)
+
+var (
+	workloadsMu sync.Mutex
+	workloads   = make(map[string]Factory)
+)
+
+// RegisterWorkload makes a workload available by name. it's usually called
+// in init of the package implementing the workload, and panics if the name is
+// registered twice.
+func RegisterWorkload(name string, factory Factory) {
+	workloadsMu.Lock()
+	defer workloadsMu.Unlock()
+	if _, ok := workloads[name]; ok {
+		panic(fmt.Sprintf("workloads: workload %s is registered twice", name))
+	}
+	workloads[name] = factory
+}
+
+// Names returns the sorted names of registered workloads.
+func Names() []string {
+	workloadsMu.Lock()
+	defer workloadsMu.Unlock()
+	var names []string
+	for name := range workloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the workload registered by name with args.
+func New(name string, args []string) (Workload, error) {
+	workloadsMu.Lock()
+	factory, ok := workloads[name]
+	workloadsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown workload %q; one of %s", name, strings.Join(Names(), ", "))
+	}
+	return factory(args)
+}
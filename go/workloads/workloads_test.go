@@ -0,0 +1,48 @@
+package workloads
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type fakeWorkload struct {
+	args []string
+}
+
+func (f *fakeWorkload) Read(ctx context.Context, id int) error  { return nil }
+func (f *fakeWorkload) Write(ctx context.Context, id int) error { return nil }
+func (f *fakeWorkload) Close() error                            { return nil }
+
+func TestRegister(t *testing.T) {
+	RegisterWorkload("fake", func(args []string) (Workload, error) {
+		return &fakeWorkload{args: args}, nil
+	})
+
+	found := false
+	for _, name := range Names() {
+		found = found || name == "fake"
+	}
+	if !found {
+		t.Errorf("Names() = %v, want to contain fake", Names())
+	}
+
+	w, err := New("fake", []string{"-a=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.(*fakeWorkload).args; !reflect.DeepEqual(got, []string{"-a=1"}) {
+		t.Errorf("args = %v, want [-a=1]", got)
+	}
+
+	if _, err := New("missing", nil); err == nil {
+		t.Error("New(missing) = nil error, want error")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterWorkload(fake) twice didn't panic")
+		}
+	}()
+	RegisterWorkload("fake", nil)
+}